@@ -0,0 +1,50 @@
+package ca
+
+import (
+	"io"
+	"os"
+
+	"github.com/andrebq/inspector/internal/certs"
+	"github.com/urfave/cli/v3"
+)
+
+func Cmd(stdout io.Writer) *cli.Command {
+	caDir := certs.DefaultDir()
+	var output string
+	return &cli.Command{
+		Name:  "ca",
+		Usage: "Manages the local CA used to intercept TLS traffic",
+		Commands: []*cli.Command{
+			{
+				Name:  "export",
+				Usage: "Writes the CA certificate (PEM) so clients can trust it, the CA is generated if needed",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "ca-dir",
+						Usage:       "Directory where the CA certificate and key are kept",
+						Value:       caDir,
+						Destination: &caDir,
+					},
+					&cli.StringFlag{
+						Name:        "output",
+						Aliases:     []string{"o"},
+						Usage:       "File where the certificate is written to",
+						DefaultText: "stdout",
+						Destination: &output,
+					},
+				},
+				Action: func(appCtx *cli.Context) error {
+					authority, err := certs.LoadOrCreate(caDir)
+					if err != nil {
+						return err
+					}
+					if output != "" {
+						return os.WriteFile(output, authority.CertPEM(), 0644)
+					}
+					_, err = stdout.Write(authority.CertPEM())
+					return err
+				},
+			},
+		},
+	}
+}
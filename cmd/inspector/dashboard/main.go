@@ -1,29 +1,156 @@
 package dashboard
 
 import (
-	"errors"
+	"context"
+	"fmt"
 	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/andrebq/inspector/internal/auth"
+	"github.com/andrebq/inspector/internal/dashboard"
 	"github.com/urfave/cli/v3"
 )
 
 func Cmd(stdout io.Writer) *cli.Command {
-	mngApi := "http://localhost:8082/request-stream"
+	var endpoints []string
+	addr := "localhost:8083"
+	var authToken, authBasic string
+	var endpointTokens, endpointBasics []string
 	return &cli.Command{
-		Name:  "dashboard",
-		Usage: "Inspector dashboard",
+		Name:                      "dashboard",
+		Usage:                     "Runs the dashboard on its own, watching proxies that might be running elsewhere",
+		DisableSliceFlagSeparator: true,
 		Flags: []cli.Flag{
-			&cli.StringFlag{
+			&cli.StringSliceFlag{
 				Name:        "endpoint",
-				Usage:       "URL where Inspector Management API is running",
-				Destination: &mngApi,
-				Value:       mngApi,
+				Usage:       "URL where Inspector Management API is running, in the form [label=]url (eg.: gateway=http://10.0.0.5:8082/), can be repeated to merge the events of many proxies",
+				Destination: &endpoints,
+				DefaultText: dashboard.DefaultAPI,
+			},
+			&cli.StringFlag{
+				Name:        "addr",
+				Aliases:     []string{"a"},
+				Usage:       "Address where the dashboard will listen for connections",
+				Destination: &addr,
+				Value:       addr,
+			},
+			&cli.StringFlag{
+				Name:        "auth-token",
+				Usage:       "Token required by the dashboard, also presented to every Management API without a credential of its own",
+				Sources:     cli.EnvVars(auth.EnvToken),
+				Destination: &authToken,
+			},
+			&cli.StringFlag{
+				Name:        "auth-basic",
+				Usage:       "Basic auth credential (user:password) required by the dashboard, also presented to every Management API without a credential of its own",
+				Sources:     cli.EnvVars(auth.EnvBasic),
+				Destination: &authBasic,
+			},
+			&cli.StringSliceFlag{
+				Name:        "endpoint-auth-token",
+				Usage:       "Token presented to a single Management API, in the form label=token (see --endpoint), can be repeated",
+				Destination: &endpointTokens,
+			},
+			&cli.StringSliceFlag{
+				Name:        "endpoint-auth-basic",
+				Usage:       "Basic auth credential presented to a single Management API, in the form label=user:password (see --endpoint), can be repeated",
+				Destination: &endpointBasics,
 			},
 		},
-		Action: func(ctx *cli.Context) error {
-			//return dashboard.Run(ctx.Context)
-			return errors.New("not implemented")
+		Action: func(appCtx *cli.Context) error {
+			ctx, cancel := context.WithCancel(appCtx.Context)
+			defer cancel()
+
+			credential, err := auth.New(authToken, authBasic)
+			if err != nil {
+				return err
+			}
+			if len(endpoints) == 0 {
+				endpoints = []string{dashboard.DefaultAPI}
+			}
+			tokens, err := byLabel(endpointTokens)
+			if err != nil {
+				return err
+			}
+			basics, err := byLabel(endpointBasics)
+			if err != nil {
+				return err
+			}
+			var sources []dashboard.Source
+			labels := map[string]bool{}
+			for _, spec := range endpoints {
+				s, err := dashboard.ParseSource(spec)
+				if err != nil {
+					return err
+				}
+				if labels[s.Label] {
+					return fmt.Errorf("endpoint label %q used more than once", s.Label)
+				}
+				labels[s.Label] = true
+				s.Credential = credential
+				if tokens[s.Label] != "" || basics[s.Label] != "" {
+					s.Credential, err = auth.New(tokens[s.Label], basics[s.Label])
+					if err != nil {
+						return fmt.Errorf("endpoint %v: %w", s.Label, err)
+					}
+				}
+				sources = append(sources, s)
+			}
+			for _, label := range append(sortedKeys(tokens), sortedKeys(basics)...) {
+				if !labels[label] {
+					return fmt.Errorf("credential given for unknown endpoint label %q", label)
+				}
+			}
+			srv := &http.Server{
+				Handler: credential.Protect(dashboard.Handler(ctx, dashboard.Options{
+					Sources: sources,
+				})),
+				BaseContext: func(l net.Listener) context.Context { return ctx },
+				Addr:        addr,
+			}
+			l, err := net.Listen("tcp", addr)
+			if err != nil {
+				return err
+			}
+			errs := make(chan error, 1)
+			go func() { errs <- srv.Serve(l) }()
+			log.Printf("Dashboard listening on %v, watching %v endpoint(s)", l.Addr(), len(sources))
+			select {
+			case err := <-errs:
+				return err
+			case <-ctx.Done():
+			}
 
+			shutdownCtx, done := context.WithTimeout(context.Background(), time.Minute)
+			defer done()
+			return srv.Shutdown(shutdownCtx)
 		},
 	}
 }
+
+// byLabel reads values in the form label=value
+func byLabel(specs []string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, spec := range specs {
+		label, value, ok := strings.Cut(spec, "=")
+		if !ok || label == "" || value == "" {
+			return nil, fmt.Errorf("invalid endpoint credential for %q, expecting label=value", label)
+		}
+		out[label] = value
+	}
+	return out, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,166 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/andrebq/inspector/internal/auth"
+	"github.com/andrebq/inspector/internal/manager"
+	"github.com/andrebq/inspector/internal/store"
+	"github.com/urfave/cli/v3"
+)
+
+func Cmd(stdout io.Writer) *cli.Command {
+	format := "har"
+	mngApi := "http://localhost:8082/"
+	var storeDir, output, method, filter string
+	var authToken, authBasic string
+	var from, to int64
+	return &cli.Command{
+		Name:  "export",
+		Usage: "Exports captured events, either from a running proxy or from a store directory",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "format",
+				Aliases:     []string{"f"},
+				Usage:       "Output format, options are: (har)",
+				Value:       format,
+				Destination: &format,
+			},
+			&cli.StringFlag{
+				Name:        "endpoint",
+				Usage:       "URL where Inspector Management API is running",
+				Value:       mngApi,
+				Destination: &mngApi,
+			},
+			&cli.StringFlag{
+				Name:        "auth-token",
+				Usage:       "Token presented to the Management API",
+				Sources:     cli.EnvVars(auth.EnvToken),
+				Destination: &authToken,
+			},
+			&cli.StringFlag{
+				Name:        "auth-basic",
+				Usage:       "Basic auth credential (user:password) presented to the Management API",
+				Sources:     cli.EnvVars(auth.EnvBasic),
+				Destination: &authBasic,
+			},
+			&cli.StringFlag{
+				Name:        "store-dir",
+				Usage:       "Read events directly from a store directory instead of the Management API",
+				Destination: &storeDir,
+			},
+			&cli.StringFlag{
+				Name:        "output",
+				Aliases:     []string{"o"},
+				Usage:       "File where the export is written to",
+				DefaultText: "stdout",
+				Destination: &output,
+			},
+			&cli.IntFlag{
+				Name:        "from",
+				Usage:       "First event ID to export",
+				Destination: &from,
+			},
+			&cli.IntFlag{
+				Name:        "to",
+				Usage:       "Last event ID to export",
+				Destination: &to,
+			},
+			&cli.StringFlag{
+				Name:        "method",
+				Usage:       "Only export requests using this HTTP method",
+				Destination: &method,
+			},
+			&cli.StringFlag{
+				Name:        "filter",
+				Usage:       "Only export requests whose URL contains this text",
+				Destination: &filter,
+			},
+		},
+		Action: func(appCtx *cli.Context) error {
+			if format != "har" {
+				return fmt.Errorf("unsupported format: %v", format)
+			}
+			q := manager.Query{
+				FromID: from,
+				ToID:   to,
+				Method: strings.ToUpper(method),
+				URL:    filter,
+			}
+			var events []*manager.IOEvent
+			var err error
+			if storeDir != "" {
+				events, err = fromStore(storeDir, q)
+			} else {
+				var credential auth.Credential
+				credential, err = auth.New(authToken, authBasic)
+				if err != nil {
+					return err
+				}
+				events, err = fromAPI(appCtx.Context, mngApi, credential, q)
+			}
+			if err != nil {
+				return err
+			}
+
+			out := stdout
+			if output != "" {
+				file, err := os.Create(output)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				out = file
+			}
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			return enc.Encode(manager.HAR(events))
+		},
+	}
+}
+
+func fromStore(dir string, q manager.Query) ([]*manager.IOEvent, error) {
+	st, err := store.OpenReadOnly(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+	return st.Query(q)
+}
+
+func fromAPI(ctx context.Context, api string, credential auth.Credential, q manager.Query) ([]*manager.IOEvent, error) {
+	if !strings.HasSuffix(api, "/") {
+		api = api + "/"
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", api+"events?"+q.Values().Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	credential.Apply(req)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response from server [%v - %v]", res.StatusCode, res.Status)
+	}
+	var events []*manager.IOEvent
+	dec := json.NewDecoder(res.Body)
+	for {
+		var ev manager.IOEvent
+		if err := dec.Decode(&ev); errors.Is(err, io.EOF) {
+			return events, nil
+		} else if err != nil {
+			return nil, err
+		}
+		events = append(events, &ev)
+	}
+}
@@ -3,7 +3,9 @@ package inspector
 import (
 	"io"
 
+	"github.com/andrebq/inspector/cmd/inspector/ca"
 	"github.com/andrebq/inspector/cmd/inspector/dashboard"
+	"github.com/andrebq/inspector/cmd/inspector/export"
 	"github.com/andrebq/inspector/cmd/inspector/proxy"
 	"github.com/urfave/cli/v3"
 )
@@ -15,6 +17,8 @@ func App(stdout io.Writer) *cli.Command {
 		Commands: []*cli.Command{
 			proxy.Cmd(),
 			dashboard.Cmd(stdout),
+			export.Cmd(stdout),
+			ca.Cmd(stdout),
 		},
 	}
 }
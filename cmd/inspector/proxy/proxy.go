@@ -2,17 +2,22 @@ package proxy
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/andrebq/inspector/internal/auth"
+	"github.com/andrebq/inspector/internal/certs"
 	"github.com/andrebq/inspector/internal/dashboard"
+	"github.com/andrebq/inspector/internal/grpcjson"
 	"github.com/andrebq/inspector/internal/manager"
+	"github.com/andrebq/inspector/internal/store"
 	"github.com/urfave/cli/v3"
 )
 
@@ -37,9 +42,15 @@ func gracefulShutdown(timeout time.Duration, servers ...*http.Server) error {
 	return ctx.Err()
 }
 
+// serve uses TLS when srv has a TLSConfig with certificates
 func serve(srv *http.Server, done func()) {
 	defer done()
-	err := srv.ListenAndServe()
+	var err error
+	if srv.TLSConfig != nil {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if err != nil {
 		log.Printf("Server %v: %v", srv.Addr, err)
 	}
@@ -49,19 +60,66 @@ func Cmd() *cli.Command {
 	var upstream string
 	proxyAddr, mngAddr := "localhost:8081", "localhost:8082"
 	dashboardAddr := "off"
+	bodyLimit := int64(manager.DefaultBodyLimit)
+	history := int64(200)
+	var storeDir string
+	var breakpoints []string
+	var mocksFile string
+	var chaosFile string
+	var protoDescriptors string
+	redactDefaults := true
+	var authToken, authBasic string
+	var redactHeaders, redactJSON, redactPatterns []string
+	var routeSpecs []string
+	mode := "reverse"
+	upstreamProto := "auto"
+	var interceptTLS bool
+	var tlsCert, tlsKey string
+	var tlsSelfSigned bool
+	caDir := certs.DefaultDir()
 
 	return &cli.Command{
 		Name:  "proxy",
 		Usage: "Runs the reverse proxy",
+		// values of repeated flags might contain commas (eg.: regular expressions)
+		DisableSliceFlagSeparator: true,
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:        "upstream",
 				Aliases:     []string{"u"},
-				Usage:       "Upstream address of the HTTP request",
+				Usage:       "Upstream address of the HTTP request, used for every path not matched by a --route",
 				DefaultText: "Eg.: http://localhost:8888",
-				Required:    true,
 				Destination: &upstream,
 			},
+			&cli.StringFlag{
+				Name:        "mode",
+				Usage:       "Proxy mode, options are: (reverse|forward). In forward mode clients use inspector as their HTTP_PROXY",
+				Value:       mode,
+				Destination: &mode,
+			},
+			&cli.StringFlag{
+				Name:        "upstream-proto",
+				Usage:       "Protocol used to reach upstreams, options are: (auto|h1|h2|h2c). auto uses HTTP/2 only when negotiated over TLS",
+				Value:       upstreamProto,
+				Destination: &upstreamProto,
+			},
+			&cli.BoolFlag{
+				Name:        "intercept-tls",
+				Usage:       "In forward mode, decrypts CONNECT tunnels using certificates signed by the local CA (see: inspector ca export)",
+				Destination: &interceptTLS,
+			},
+			&cli.StringFlag{
+				Name:        "ca-dir",
+				Usage:       "Directory where the CA certificate and key are kept, a new CA is generated if it is empty",
+				Value:       caDir,
+				Destination: &caDir,
+			},
+			&cli.StringSliceFlag{
+				Name:        "route",
+				Aliases:     []string{"r"},
+				Usage:       "Sends requests under a path prefix to another upstream, in the form prefix=url[,strip] (eg.: /api=http://localhost:9000,strip), can be repeated",
+				Destination: &routeSpecs,
+			},
 			&cli.StringFlag{
 				Name:        "proxy-addr",
 				Aliases:     []string{"p"},
@@ -69,6 +127,21 @@ func Cmd() *cli.Command {
 				Value:       proxyAddr,
 				Destination: &proxyAddr,
 			},
+			&cli.StringFlag{
+				Name:        "tls-cert",
+				Usage:       "Certificate (PEM) used to serve the proxy over TLS, requires --tls-key",
+				Destination: &tlsCert,
+			},
+			&cli.StringFlag{
+				Name:        "tls-key",
+				Usage:       "Private key (PEM) matching --tls-cert",
+				Destination: &tlsKey,
+			},
+			&cli.BoolFlag{
+				Name:        "tls-self-signed",
+				Usage:       "Serves the proxy over TLS using a certificate generated for the host in --proxy-addr",
+				Destination: &tlsSelfSigned,
+			},
 			&cli.StringFlag{
 				Name:        "management-addr",
 				Aliases:     []string{"m", "mng-addr"},
@@ -76,6 +149,18 @@ func Cmd() *cli.Command {
 				Value:       mngAddr,
 				Destination: &mngAddr,
 			},
+			&cli.StringFlag{
+				Name:        "auth-token",
+				Usage:       "Token required by the management server and dashboard, either as a bearer token or as the basic auth password",
+				Sources:     cli.EnvVars(auth.EnvToken),
+				Destination: &authToken,
+			},
+			&cli.StringFlag{
+				Name:        "auth-basic",
+				Usage:       "Basic auth credential (user:password) required by the management server and dashboard",
+				Sources:     cli.EnvVars(auth.EnvBasic),
+				Destination: &authBasic,
+			},
 			&cli.StringFlag{
 				Name:        "dashboard",
 				Aliases:     []string{"d"},
@@ -83,21 +168,181 @@ func Cmd() *cli.Command {
 				Value:       dashboardAddr,
 				Destination: &dashboardAddr,
 			},
+			&cli.IntFlag{
+				Name:        "body-limit",
+				Usage:       "Maximum number of bytes captured from each request/response body (bodies are still fully proxied)",
+				Value:       bodyLimit,
+				Destination: &bodyLimit,
+			},
+			&cli.IntFlag{
+				Name:        "history",
+				Usage:       "Number of recent events kept in memory and replayed to dashboards that connect later (0 to disable)",
+				Value:       history,
+				Destination: &history,
+			},
+			&cli.StringFlag{
+				Name:        "store-dir",
+				Usage:       "Directory where captured events are persisted across restarts (empty keeps everything in memory)",
+				Destination: &storeDir,
+			},
+			&cli.StringSliceFlag{
+				Name:        "break",
+				Usage:       "Holds exchanges until they are released from the dashboard, in the form phase:method:path-regexp (eg.: request:POST:^/api/), can be repeated",
+				Destination: &breakpoints,
+			},
+			&cli.StringFlag{
+				Name:        "mocks",
+				Usage:       "JSON file with a list of mock rules answering matching requests instead of the upstream",
+				Destination: &mocksFile,
+			},
+			&cli.StringFlag{
+				Name:        "chaos",
+				Usage:       "JSON file with a list of fault rules (route, probability, latency, status, truncate, reset) injecting failures on purpose",
+				Destination: &chaosFile,
+			},
+			&cli.StringFlag{
+				Name:        "proto-descriptors",
+				Usage:       "FileDescriptorSet (protoc --include_imports --descriptor_set_out) used to decode gRPC messages as JSON",
+				Destination: &protoDescriptors,
+			},
+			&cli.BoolFlag{
+				Name:        "redact-defaults",
+				Usage:       "Hides the values of " + strings.Join(manager.DefaultRedactedHeaders, ", ") + " headers from captured events",
+				Value:       redactDefaults,
+				Destination: &redactDefaults,
+			},
+			&cli.StringSliceFlag{
+				Name:        "redact-header",
+				Usage:       "Hides the values of a header from captured events, can be repeated",
+				Destination: &redactHeaders,
+			},
+			&cli.StringSliceFlag{
+				Name:        "redact-json",
+				Usage:       "Hides a field from JSON bodies, using a dotted path where * matches anything (eg.: user.password or items.*.token), can be repeated",
+				Destination: &redactJSON,
+			},
+			&cli.StringSliceFlag{
+				Name:        "redact-regex",
+				Usage:       "Hides matches of a regular expression from URLs, header values and bodies, can be repeated",
+				Destination: &redactPatterns,
+			},
 		},
 		Action: func(appCtx *cli.Context) error {
 			ctx, cancel := context.WithCancel(appCtx.Context)
+			defer cancel()
 
-			remote, err := url.Parse(upstream)
+			var routes []*manager.Route
+			for _, spec := range routeSpecs {
+				r, err := manager.ParseRoute(spec)
+				if err != nil {
+					return err
+				}
+				routes = append(routes, r)
+			}
+			if upstream != "" {
+				r, err := manager.ParseRoute("/=" + upstream)
+				if err != nil {
+					return err
+				}
+				routes = append(routes, r)
+			}
+			transport, err := manager.NewTransport(upstreamProto)
 			if err != nil {
-				panic(err)
+				return err
+			}
+			for _, r := range routes {
+				r.Proxy().Transport = transport
 			}
-
-			proxy := httputil.NewSingleHostReverseProxy(remote)
 			mng := &manager.M{
-				Upstream: proxy,
+				Routes:    manager.NewRouter(routes...),
+				Transport: transport,
+				BodyLimit: bodyLimit,
+				History:   int(history),
+			}
+			switch mode {
+			case "reverse":
+				if len(routes) == 0 {
+					return errors.New("at least one --upstream or --route is required")
+				}
+			case "forward":
+				if len(routes) != 0 {
+					return errors.New("--upstream and --route cannot be used in forward mode")
+				}
+				mng.Forward = true
+			default:
+				return fmt.Errorf("invalid mode: %v", mode)
+			}
+			if interceptTLS {
+				if !mng.Forward {
+					return errors.New("--intercept-tls requires --mode forward")
+				}
+				authority, err := certs.LoadOrCreate(caDir)
+				if err != nil {
+					return err
+				}
+				mng.Intercept = authority
+			}
+			for _, spec := range breakpoints {
+				bp, err := manager.ParseBreakpoint(spec)
+				if err != nil {
+					return err
+				}
+				if _, err := mng.AddBreakpoint(bp); err != nil {
+					return err
+				}
+			}
+			if mocksFile != "" {
+				rules, err := manager.LoadMocks(mocksFile)
+				if err != nil {
+					return err
+				}
+				for _, r := range rules {
+					if _, err := mng.AddMock(r); err != nil {
+						return err
+					}
+				}
+			}
+			if chaosFile != "" {
+				rules, err := manager.LoadFaults(chaosFile)
+				if err != nil {
+					return err
+				}
+				mng.SetFaults(rules)
+			}
+			if redactDefaults || len(redactHeaders) > 0 || len(redactJSON) > 0 || len(redactPatterns) > 0 {
+				r, err := manager.NewRedactor(redactDefaults, redactHeaders, redactJSON, redactPatterns)
+				if err != nil {
+					return err
+				}
+				mng.Redact = r
+			}
+			if protoDescriptors != "" {
+				dec, err := grpcjson.Load(protoDescriptors)
+				if err != nil {
+					return err
+				}
+				mng.GRPC = dec
+			}
+			if storeDir != "" {
+				st, err := store.Open(storeDir)
+				if err != nil {
+					return err
+				}
+				defer st.Close()
+				mng.Store = st
 			}
 
+			credential, err := auth.New(authToken, authBasic)
+			if err != nil {
+				return err
+			}
+			proxyTLS, err := listenerTLS(proxyAddr, tlsCert, tlsKey, tlsSelfSigned)
+			if err != nil {
+				return err
+			}
 			proxyServer := &http.Server{
+				TLSConfig: proxyTLS,
+				Protocols: listenerProtocols(),
 				BaseContext: func(l net.Listener) context.Context {
 					return ctx
 				},
@@ -108,7 +353,7 @@ func Cmd() *cli.Command {
 				BaseContext: func(l net.Listener) context.Context {
 					return ctx
 				},
-				Handler: mng.Manager(),
+				Handler: credential.Protect(mng.Manager()),
 				Addr:    mngAddr,
 			}
 
@@ -126,7 +371,10 @@ func Cmd() *cli.Command {
 				dashboardAddr = ""
 			}
 			if dashboardAddr != "" {
-				handler := dashboard.Handler(ctx)
+				handler := credential.Protect(dashboard.Handler(ctx, dashboard.Options{
+					API:        "http://" + mngAddr + "/",
+					Credential: credential,
+				}))
 				dsSrv := &http.Server{
 					Handler:     handler,
 					BaseContext: func(l net.Listener) context.Context { return ctx },
@@ -141,3 +389,38 @@ func Cmd() *cli.Command {
 		},
 	}
 }
+
+// listenerTLS returns the TLS configuration for the proxy listener,
+// or nil when it should use plain HTTP
+func listenerTLS(addr, certFile, keyFile string, selfSigned bool) (*tls.Config, error) {
+	switch {
+	case selfSigned && (certFile != "" || keyFile != ""):
+		return nil, errors.New("--tls-self-signed cannot be used with --tls-cert/--tls-key")
+	case selfSigned:
+		cert, err := certs.SelfSigned(addr)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{Certificates: []tls.Certificate{*cert}}, nil
+	case certFile != "" || keyFile != "":
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("--tls-cert and --tls-key must be used together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+	}
+	return nil, nil
+}
+
+// listenerProtocols accepts HTTP/1.1 and HTTP/2, either over TLS
+// or in cleartext (h2c, prior knowledge)
+func listenerProtocols() *http.Protocols {
+	p := new(http.Protocols)
+	p.SetHTTP1(true)
+	p.SetHTTP2(true)
+	p.SetUnencryptedHTTP2(true)
+	return p
+}
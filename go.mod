@@ -1,8 +1,12 @@
 module github.com/andrebq/inspector
 
-go 1.20
+go 1.24
 
-require github.com/urfave/cli/v3 v3.0.0-alpha4
+require (
+	github.com/andybalholm/brotli v1.2.6
+	github.com/urfave/cli/v3 v3.0.0-alpha4
+	google.golang.org/protobuf v1.36.11
+)
 
 require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.2 // indirect
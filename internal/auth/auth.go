@@ -0,0 +1,92 @@
+// Package auth protects the management API and the dashboard
+// with either a bearer token or basic auth
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// EnvToken and EnvBasic hold the credential when flags are not used
+	EnvToken = "INSPECTOR_AUTH_TOKEN"
+	EnvBasic = "INSPECTOR_AUTH_BASIC"
+)
+
+type (
+	// Credential is either a Token or a User/Password pair, the zero
+	// value disables authentication
+	Credential struct {
+		Token    string
+		User     string
+		Password string
+	}
+)
+
+// New returns a credential from a token or a basic auth pair
+// in the form user:password, only one of them can be used
+func New(token, basic string) (Credential, error) {
+	switch {
+	case token != "" && basic != "":
+		return Credential{}, fmt.Errorf("use either a token or basic auth, not both")
+	case basic != "":
+		user, password, ok := strings.Cut(basic, ":")
+		if !ok || user == "" || password == "" {
+			return Credential{}, fmt.Errorf("basic auth must be in the form user:password")
+		}
+		return Credential{User: user, Password: password}, nil
+	}
+	return Credential{Token: token}, nil
+}
+
+// Enabled reports if requests must be authenticated
+func (c Credential) Enabled() bool {
+	return c.Token != "" || c.User != ""
+}
+
+// Protect only lets authenticated requests reach h. Tokens are accepted
+// as bearer tokens or as the password of basic auth (so browsers can
+// use them as well)
+func (c Credential) Protect(h http.Handler) http.Handler {
+	if !c.Enabled() {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !c.valid(req) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="inspector", charset="UTF-8"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+func (c Credential) valid(req *http.Request) bool {
+	if c.Token != "" {
+		if bearer, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok {
+			return equal(bearer, c.Token)
+		}
+		_, password, ok := req.BasicAuth()
+		return ok && equal(password, c.Token)
+	}
+	user, password, ok := req.BasicAuth()
+	// evaluate both to avoid leaking which one is wrong
+	userOK, passwordOK := equal(user, c.User), equal(password, c.Password)
+	return ok && userOK && passwordOK
+}
+
+// Apply adds the credential to an outgoing request
+func (c Credential) Apply(req *http.Request) {
+	switch {
+	case c.Token != "":
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	case c.User != "":
+		req.SetBasicAuth(c.User, c.Password)
+	}
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
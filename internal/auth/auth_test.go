@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProtect(t *testing.T) {
+	token, err := New("s3cret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	basic, err := New("", "ann:pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		credential Credential
+		header     string
+		user, pass string
+		want       int
+	}{
+		{name: "disabled", want: http.StatusOK},
+		{name: "token missing", credential: token, want: http.StatusUnauthorized},
+		{name: "bearer token", credential: token, header: "Bearer s3cret", want: http.StatusOK},
+		{name: "wrong bearer token", credential: token, header: "Bearer nope", want: http.StatusUnauthorized},
+		{name: "token as basic password", credential: token, user: "anyone", pass: "s3cret", want: http.StatusOK},
+		{name: "token as empty user password", credential: token, pass: "s3cret", want: http.StatusOK},
+		{name: "token as basic user", credential: token, user: "s3cret", pass: "x", want: http.StatusUnauthorized},
+		{name: "basic", credential: basic, user: "ann", pass: "pw", want: http.StatusOK},
+		{name: "basic wrong user", credential: basic, user: "bob", pass: "pw", want: http.StatusUnauthorized},
+		{name: "basic wrong password", credential: basic, user: "ann", pass: "nope", want: http.StatusUnauthorized},
+		{name: "basic password as bearer", credential: basic, header: "Bearer pw", want: http.StatusUnauthorized},
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		} else if tt.user != "" || tt.pass != "" {
+			req.SetBasicAuth(tt.user, tt.pass)
+		}
+		rec := httptest.NewRecorder()
+		tt.credential.Protect(ok).ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.name, rec.Code, tt.want)
+		}
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%v: missing WWW-Authenticate", tt.name)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, tt := range []struct{ token, basic string }{{"t", "u:p"}, {"", "user"}, {"", ":p"}, {"", "u:"}} {
+		if _, err := New(tt.token, tt.basic); err == nil {
+			t.Errorf("%q %q: expecting an error", tt.token, tt.basic)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Package certs generates the certificates inspector needs to
+// decrypt (and serve) TLS traffic
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	caCertFile = "ca.pem"
+	caKeyFile  = "ca-key.pem"
+)
+
+type (
+	// Authority is a local root CA used to mint leaf certificates on the fly
+	Authority struct {
+		Cert *x509.Certificate
+		Key  crypto.Signer
+
+		lock  sync.Mutex
+		cache map[string]*tls.Certificate
+	}
+)
+
+// DefaultDir is where the CA is kept when no directory is given
+func DefaultDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return filepath.Join(".", "inspector-ca")
+	}
+	return filepath.Join(dir, "inspector", "ca")
+}
+
+// LoadOrCreate reads the CA from dir, generating (and saving)
+// a new one if dir does not contain a CA yet
+func LoadOrCreate(dir string) (*Authority, error) {
+	certPEM, err := os.ReadFile(filepath.Join(dir, caCertFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return create(dir)
+	} else if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(dir, caKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CA in %v: %w", dir, err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported CA key in %v", dir)
+	}
+	return &Authority{Cert: cert, Key: key}, nil
+}
+
+func create(dir string) (*Authority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial(),
+		Subject:               pkix.Name{CommonName: "Inspector Local CA", Organization: []string{"Inspector"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, caKeyFile), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, caCertFile), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return nil, err
+	}
+	return &Authority{Cert: cert, Key: key}, nil
+}
+
+// CertPEM returns the CA certificate, which clients should trust
+func (a *Authority) CertPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: a.Cert.Raw})
+}
+
+// Leaf returns a certificate for host signed by the CA, certificates
+// are kept in memory and reused until they are about to expire
+func (a *Authority) Leaf(host string) (*tls.Certificate, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.cache == nil {
+		a.cache = make(map[string]*tls.Certificate)
+	}
+	if c, ok := a.cache[host]; ok && time.Until(c.Leaf.NotAfter) > time.Hour {
+		return c, nil
+	}
+	c, err := issue(a.Cert, a.Key, []string{host}, time.Now().AddDate(0, 0, 7))
+	if err != nil {
+		return nil, err
+	}
+	a.cache[host] = c
+	return c, nil
+}
+
+// ServerConfig returns the TLS configuration used to impersonate host,
+// the SNI sent by the client takes precedence over host
+func (a *Authority) ServerConfig(host string) (*tls.Config, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return &tls.Config{
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName != "" {
+				return a.Leaf(hello.ServerName)
+			}
+			return a.Leaf(host)
+		},
+	}, nil
+}
+
+// SelfSigned returns a certificate valid for the host in addr, when addr
+// does not name a specific host (eg.: :8081) localhost is used instead
+func SelfSigned(addr string) (*tls.Certificate, error) {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	hosts := []string{host}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		hosts = []string{"localhost", "127.0.0.1", "::1"}
+	}
+	return issue(nil, nil, hosts, time.Now().AddDate(1, 0, 0))
+}
+
+// issue creates a certificate for hosts signed by parent, or a self-signed
+// certificate when parent is nil
+func issue(parent *x509.Certificate, parentKey crypto.Signer, hosts []string, notAfter time.Time) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial(),
+		Subject:      pkix.Name{CommonName: hosts[0], Organization: []string{"Inspector"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+func serial() *big.Int {
+	n, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
@@ -0,0 +1,68 @@
+package dashboard
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"mime"
+	"strings"
+
+	"github.com/andrebq/inspector/internal/manager"
+)
+
+// hexLimit caps how much of a binary body is shown as a hex dump
+const hexLimit = 4096
+
+type (
+	// bodyView is what the templates need to render a body,
+	// Kind is one of: empty, text, json, image or binary
+	bodyView struct {
+		Kind  string
+		Text  string
+		Image template.URL
+		// Clipped is set when the hex dump does not cover the whole body
+		Clipped bool
+	}
+)
+
+var funcs = template.FuncMap{
+	"body":    messageView,
+	"payload": frameView,
+}
+
+func messageView(msg manager.Message) bodyView {
+	return newBodyView(msg.ContentType(), msg.Content(), msg.ContentIsText())
+}
+
+func frameView(f *manager.Frame) bodyView {
+	return newBodyView("", f.Payload, f.IsText())
+}
+
+// newBodyView picks how to show data based on its content type
+func newBodyView(contentType string, data []byte, text bool) bodyView {
+	if len(data) == 0 {
+		return bodyView{Kind: "empty"}
+	}
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mt, "image/") && mt != "image/svg+xml":
+		return bodyView{
+			Kind:  "image",
+			Image: template.URL("data:" + mt + ";base64," + base64.StdEncoding.EncodeToString(data)),
+		}
+	case !text:
+		dump := data
+		if len(dump) > hexLimit {
+			dump = dump[:hexLimit]
+		}
+		return bodyView{Kind: "binary", Text: hex.Dump(dump), Clipped: len(dump) < len(data)}
+	case strings.HasSuffix(mt, "json"):
+		buf := &bytes.Buffer{}
+		if err := json.Indent(buf, data, "", "  "); err == nil {
+			return bodyView{Kind: "json", Text: buf.String()}
+		}
+	}
+	return bodyView{Kind: "text", Text: string(data)}
+}
@@ -0,0 +1,177 @@
+package dashboard
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andrebq/inspector/internal/manager"
+)
+
+type (
+	// heldExchange is a held exchange along with the label of its source
+	heldExchange struct {
+		*manager.Held
+		Source string
+	}
+
+	// sourceBreakpoint is a breakpoint along with the label of its source
+	sourceBreakpoint struct {
+		manager.Breakpoint
+		Source string
+	}
+)
+
+// held lists every exchange waiting for a decision, from every source
+func (r *rootHandler) held(w http.ResponseWriter, req *http.Request) {
+	var all []heldExchange
+	for _, s := range r.sources {
+		var held []*manager.Held
+		if _, err := s.apiCall(req.Context(), "GET", "held", nil, &held); err != nil {
+			log.Printf("Unable to list held exchanges of %v: %v", s.Label, err)
+		}
+		for _, h := range held {
+			all = append(all, heldExchange{Held: h, Source: s.Label})
+		}
+	}
+	r.renderTemplate(w, req, "held.html", "held", all)
+}
+
+// heldItem renders the decision form on GET and sends the decision on POST
+func (r *rootHandler) heldItem(w http.ResponseWriter, req *http.Request) {
+	src, err := r.source(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(req.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid request id", http.StatusBadRequest)
+		return
+	}
+	if req.Method == http.MethodPost {
+		r.decide(w, req, src, id)
+		return
+	}
+	var held []*manager.Held
+	if _, err := src.apiCall(req.Context(), "GET", "held", nil, &held); err != nil {
+		log.Printf("Unable to list held exchanges: %v", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	for _, h := range held {
+		if h.Event.ID != id {
+			continue
+		}
+		msg := h.Event.Request
+		if h.Phase == manager.PhaseResponse {
+			msg = h.Event.Response
+		}
+		r.renderTemplate(w, req, "held-item.html", "held-item", struct {
+			heldExchange
+			HeaderText string
+			Body       string
+			Binary     bool
+		}{
+			heldExchange: heldExchange{Held: h, Source: src.Label},
+			HeaderText:   formatHeaders(msg.Headers),
+			Body:         string(msg.Body),
+			Binary:       !msg.IsText(),
+		})
+		return
+	}
+	r.renderTemplate(w, req, "decided.html", "decided", struct {
+		ID     int64
+		Action string
+	}{ID: id})
+}
+
+func (r *rootHandler) decide(w http.ResponseWriter, req *http.Request, src *Source, id int64) {
+	d := manager.Decision{Action: req.FormValue("action")}
+	if d.Action == manager.ActionEdit {
+		headers, err := parseHeaders(req.FormValue("headers"))
+		if err != nil {
+			http.Error(w, "invalid headers: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		d.Headers = headers
+		// binary bodies are not editable, so the form has no body field
+		if _, ok := req.Form["body"]; ok {
+			body := req.FormValue("body")
+			d.Body = &body
+		}
+		d.Method = strings.TrimSpace(req.FormValue("method"))
+		d.URL = strings.TrimSpace(req.FormValue("url"))
+		if code := strings.TrimSpace(req.FormValue("code")); code != "" {
+			d.Code, err = strconv.Atoi(code)
+			if err != nil {
+				http.Error(w, "invalid status code", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	found, err := src.apiCall(req.Context(), "POST", fmt.Sprintf("held/%v", id), d, nil)
+	if err != nil {
+		log.Printf("Unable to release exchange %v: %v", id, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if !found {
+		d.Action = ""
+	}
+	r.renderTemplate(w, req, "decided.html", "decided", struct {
+		ID     int64
+		Action string
+	}{ID: id, Action: d.Action})
+}
+
+// breakpoints lists the breakpoints of every source on GET, adds a new one
+// on POST and removes the one identified by id on DELETE
+func (r *rootHandler) breakpoints(w http.ResponseWriter, req *http.Request) {
+	src, err := r.source(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch req.Method {
+	case http.MethodPost:
+		b := manager.Breakpoint{
+			Phase:  req.FormValue("phase"),
+			Method: strings.TrimSpace(req.FormValue("method")),
+			Path:   strings.TrimSpace(req.FormValue("path")),
+		}
+		_, err = src.apiCall(req.Context(), "POST", "breakpoints", b, nil)
+	case http.MethodDelete:
+		var id int64
+		id, err = strconv.ParseInt(req.FormValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid breakpoint id", http.StatusBadRequest)
+			return
+		}
+		_, err = src.apiCall(req.Context(), "DELETE", fmt.Sprintf("breakpoints/%v", id), nil, nil)
+	}
+	if err != nil {
+		log.Printf("Unable to change breakpoints: %v", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	var all []sourceBreakpoint
+	for _, s := range r.sources {
+		var list []manager.Breakpoint
+		if _, err := s.apiCall(req.Context(), "GET", "breakpoints", nil, &list); err != nil {
+			log.Printf("Unable to list breakpoints of %v: %v", s.Label, err)
+		}
+		for _, b := range list {
+			all = append(all, sourceBreakpoint{Breakpoint: b, Source: s.Label})
+		}
+	}
+	r.renderTemplate(w, req, "breakpoints.html", "breakpoints", struct {
+		Breakpoints []sourceBreakpoint
+		Sources     []string
+	}{
+		Breakpoints: all,
+		Sources:     r.labels(),
+	})
+}
@@ -55,5 +55,77 @@ pre {
 	border-left: solid 0.5rem #96ccff;
 	padding-left: 1rem;
 }
+
+.tag {
+	font-size: 80%;
+	padding: 0 0.3rem;
+	border-radius: 0.3rem;
+	background-color: #96ccff;
+}
+
+.tag.fault {
+	background-color: #ff725c;
+}
+
+.tag.source {
+	background-color: #9eebcf;
+}
+
+.composer label {
+	display: block;
+	margin-bottom: 0.5rem;
+	font-weight: bold;
+}
+
+.composer textarea {
+	display: block;
+	width: 100%;
+	font-family: monospace;
+}
+
+.waterfall {
+	width: 100%;
+}
+
+.waterfall td {
+	padding: 0 0.3rem;
+	white-space: nowrap;
+}
+
+.waterfall td.bar {
+	width: 70%;
+}
+
+.waterfall td.bar div {
+	height: 0.8rem;
+	min-width: 1px;
+	background-color: #357edd;
+}
+
+.chat li {
+	max-width: 70%;
+	margin: 0.3rem 0;
+	padding: 0.3rem 0.6rem;
+	border-radius: 0.5rem;
+}
+
+.chat li.client {
+	margin-right: auto;
+	background-color: #cdecff;
+}
+
+.chat li.server {
+	margin-left: auto;
+	background-color: #e8fdf5;
+}
+
+.chat li pre {
+	white-space: pre-wrap;
+	word-break: break-all;
+}
+
+.chat small {
+	color: #777;
+}
 	`
 )
@@ -28,7 +28,12 @@ const (
 {{ template "body-intro" }}
 <div class="flex h-100">
 	<section class="vflow w-20">
+		<h1 style="margin: 1rem">Held</h1>
+		<a class="pill" href="/breakpoints" hx-get="/breakpoints" hx-target="#request-inspector" hx-swap="innerHTML">Breakpoints</a>
+		<ul hx-get="/held" hx-trigger="every 1s" hx-swap="morphdom" class="pill">
+		</ul>
 		<h1 style="margin: 1rem">Requests</h1>
+		<a class="pill" href="/compose" hx-get="/compose" hx-target="#request-inspector" hx-swap="innerHTML">New request</a>
 		<ul hx-get="/requests" hx-trigger="every 2s" hx-swap="morphdom" style="overflow-y: auto" class="vflow pill">
 		</ul>
 	</section>
@@ -50,17 +55,166 @@ const (
 {{define "requests" }}
 <ul hx-get="/requests" hx-trigger="every 2s" hx-swap="morphdom" class="vflow pill" style="overflow-y: auto">
 {{ range .Requests -}}
-<li class="bg-light-pink" id="rid-{{.ID}}"><a href="/inspect-request?rid={{.ID}}" hx-get="/inspect-request?rid={{.ID}}" hx-target="#request-inspector" hx-swap="innerHTML">{{.ID}} : {{ .Code }} - {{ .URL }}</a></li>
+<li class="bg-light-pink" id="rid-{{.Source}}-{{.ID}}"><a href="/inspect-request?source={{.Source}}&rid={{.ID}}" hx-get="/inspect-request?source={{.Source}}&rid={{.ID}}" hx-target="#request-inspector" hx-swap="innerHTML" title="{{.RemoteAddr}} {{.Proto}}">{{ if $.Labeled }}<span class="tag source">{{.Source}}</span> {{ end }}{{.ID}} : {{ .Start.Format "15:04:05" }} {{ .Method }} {{ .Code }} - {{ .URL }}{{ if .Elapsed }} <small>{{.Elapsed}}</small>{{ end }}{{ range .Tags }} <span class="tag">{{.}}</span>{{ end }}{{ range .Faults }} <span class="tag fault">{{.Kind}}</span>{{ end }}</a></li>
+{{- end }}
+</ul>
+{{end}}
+
+{{define "held" }}
+<ul hx-get="/held" hx-trigger="every 1s" hx-swap="morphdom" class="pill">
+{{ range . -}}
+<li class="bg-light-yellow" id="held-{{.Source}}-{{.Event.ID}}"><a href="/held-item?source={{.Source}}&id={{.Event.ID}}" hx-get="/held-item?source={{.Source}}&id={{.Event.ID}}" hx-target="#request-inspector" hx-swap="innerHTML">{{ with .Source }}<span class="tag source">{{.}}</span> {{ end }}{{.Event.ID}} : {{.Phase}} - {{.Event.Method}} {{.Event.URL}}</a></li>
 {{- end }}
 </ul>
 {{end}}
 
+{{define "held-item"}}
+<form hx-post="/held-item" hx-target="#request-inspector" hx-swap="innerHTML" class="composer">
+	<input type="hidden" name="id" value="{{.Event.ID}}" />
+	<input type="hidden" name="source" value="{{.Source}}" />
+	<h2>{{.Event.ID}} held on {{.Phase}}{{ with .Source }} by {{.}}{{ end }}</h2>
+	{{ if eq .Phase "request" }}
+	<label>Method <input name="method" value="{{.Event.Method}}" size="8" /></label>
+	<label>URL <input name="url" value="{{.Event.URL}}" size="80" /></label>
+	{{ else }}
+	<p>{{.Event.Method}} {{.Event.URL}}</p>
+	<label>Status <input name="code" value="{{.Event.Code}}" size="4" /></label>
+	{{ end }}
+	<label>Headers <textarea name="headers" rows="10">{{.HeaderText}}</textarea></label>
+	{{ if .Binary }}
+	<p>Binary body ({{len .Body}} bytes) is sent unchanged</p>
+	{{ else }}
+	<label>Body <textarea name="body" rows="15">{{.Body}}</textarea></label>
+	{{ end }}
+	<button type="submit" name="action" value="approve">Approve</button>
+	<button type="submit" name="action" value="edit">Send edited</button>
+	<button type="submit" name="action" value="drop">Drop</button>
+</form>
+{{end}}
+
+{{define "decided"}}
+{{ if .Action }}
+<p>{{.ID}} released with action: {{.Action}}</p>
+{{ else }}
+<p>{{.ID}} is no longer held</p>
+{{ end }}
+{{end}}
+
+{{define "breakpoints"}}
+<h2>Breakpoints</h2>
+<ul>
+{{ range .Breakpoints }}
+<li>{{ with .Source }}<span class="tag source">{{.}}</span> {{ end }}{{.ID}} : {{.Phase}} {{ or .Method "*" }} {{ or .Path "*" }}
+	<button hx-delete="/breakpoints?source={{.Source}}&id={{.ID}}" hx-target="#request-inspector" hx-swap="innerHTML">Remove</button></li>
+{{ end }}
+</ul>
+<form hx-post="/breakpoints" hx-target="#request-inspector" hx-swap="innerHTML" class="composer">
+	{{ template "source-select" .Sources }}
+	<label>Phase <select name="phase"><option>request</option><option>response</option></select></label>
+	<label>Method <input name="method" placeholder="any" size="8" /></label>
+	<label>Path (regular expression) <input name="path" placeholder="any" size="40" /></label>
+	<button type="submit">Add</button>
+</form>
+{{end}}
+
+{{define "source-select"}}
+{{ if gt (len .) 1 }}
+<label>Proxy <select name="source">{{ range . }}<option>{{.}}</option>{{ end }}</select></label>
+{{ end }}
+{{end}}
+
+{{define "compose"}}
+<form hx-post="/compose" hx-target="#request-inspector" hx-swap="innerHTML" class="composer">
+	{{ if gt (len .Sources) 1 }}
+	<label>Proxy <select name="source">{{ range .Sources }}<option{{ if eq . $.Source }} selected{{ end }}>{{.}}</option>{{ end }}</select></label>
+	{{ else }}
+	<input type="hidden" name="source" value="{{.Source}}" />
+	{{ end }}
+	<label>Method <input name="method" value="{{.Method}}" size="8" /></label>
+	<label>URL <input name="url" value="{{.URL}}" size="80" /></label>
+	<label>Host <input name="host" value="{{.Host}}" size="30" /></label>
+	<label>Headers <textarea name="headers" rows="10">{{.HeaderText}}</textarea></label>
+	<label>Body <textarea name="body" rows="15">{{.Body}}</textarea></label>
+	{{ with .From }}<input type="hidden" name="from" value="{{.}}" />{{ end }}
+	<button type="submit">Send</button>
+</form>
+{{end}}
+
 {{define "inspect-request"}}
+<button hx-post="/replay?source={{.Source}}&rid={{.ID}}" hx-target="#request-inspector" hx-swap="innerHTML">Replay</button>
+<button hx-get="/compose?source={{.Source}}&rid={{.ID}}" hx-target="#request-inspector" hx-swap="innerHTML">Edit and resend</button>
 <dl>
 	<dt>ID</dt>
 	<dd>{{.ID}}</dd>
+	{{ with .Source }}
+	<dt>Proxy</dt>
+	<dd><span class="tag source">{{.}}</span></dd>
+	{{ end }}
+	{{ if .Tags }}
+	<dt>Tags</dt>
+	<dd>{{ range .Tags }}<span class="tag">{{.}}</span> {{ end }}</dd>
+	{{ end }}
+	{{ if .Faults }}
+	<dt>Injected faults</dt>
+	<dd>
+		<ul>
+			{{ range .Faults }}
+			<li><span class="tag fault">{{.Kind}}</span> {{.Detail}}</li>
+			{{ end }}
+		</ul>
+	</dd>
+	{{ end }}
+	{{ if .DroppedHeaders }}
+	<dt>Dropped headers</dt>
+	<dd>{{ range .DroppedHeaders }}<span class="tag fault">{{.}}</span> {{ end }}<small>redacted values no longer known</small></dd>
+	{{ end }}
+	{{ if .ReplayOf }}
+	<dt>Replay of</dt>
+	<dd><a href="/inspect-request?source={{.Source}}&rid={{.ReplayOf}}" hx-get="/inspect-request?source={{.Source}}&rid={{.ReplayOf}}" hx-target="#request-inspector" hx-swap="innerHTML">{{.ReplayOf}}</a></dd>
+	{{ end }}
+	<dt>Started</dt>
+	<dd>{{.Start.Format "2006-01-02 15:04:05.000"}}</dd>
+	{{ if not .End.IsZero }}
+	<dt>Finished</dt>
+	<dd>{{.End.Format "2006-01-02 15:04:05.000"}} ({{.Elapsed}})</dd>
+	{{ end }}
+	{{ with .RemoteAddr }}
+	<dt>Client</dt>
+	<dd>{{.}}</dd>
+	{{ end }}
+	<dt>Method</dt>
+	<dd>{{.Method}}</dd>
+	<dt>Host</dt>
+	<dd>{{.Host}}</dd>
 	<dt>URL</dt>
 	<dd>{{.URL}}</dd>
+	{{ if .Upstream }}
+	<dt>Upstream</dt>
+	<dd>{{.Upstream}}</dd>
+	{{ end }}
+	{{ if .Proto }}
+	<dt>Protocol</dt>
+	<dd>{{.Proto}}{{ with .UpstreamProto }} (upstream: {{.}}){{ end }}</dd>
+	{{ end }}
+	{{ with .Timings }}
+	<dt>Timings</dt>
+	<dd>
+		<table class="waterfall">
+			{{ range .Waterfall }}
+			<tr>
+				<td>{{.Name}}</td>
+				<td class="bar"><div style="margin-left: {{.Offset}}%; width: {{.Width}}%"></div></td>
+				<td>{{.Duration}}</td>
+			</tr>
+			{{ end }}
+			<tr><td>Total</td><td class="bar"></td><td>{{.Total}}{{ if .Reused }} (reused connection){{ end }}</td></tr>
+		</table>
+	</dd>
+	{{ end }}
+	{{ with .Tunnel }}
+	<dt>Tunnel</dt>
+	<dd>{{.Host}} for {{.Duration}}, {{.BytesSent}} bytes sent and {{.BytesReceived}} bytes received</dd>
+	{{ end }}
 	<hr />
 	<dt>Request Headers</dt>
 	<dd>
@@ -70,20 +224,70 @@ const (
 			{{end}}
 		</ul>
 	</dd>
-	<dt>Request body</dt>
-	<dd><pre class="limit-h">{{.Request.Body}}</pre></dd>
+	<dt>Request body ({{.Request.Size}} bytes{{ if .Request.Truncated }}, truncated{{ end }}{{ with .Request.ContentEncoding }}, {{.}} decoded at {{printf "%.1f" $.Request.CompressionRatio}}x{{ end }})</dt>
+	<dd>{{ template "body" (body .Request) }}</dd>
 	<hr />
 	<dt>Response Headers</dt>
 	<dd>
 		<ul>
-			{{range $k, $v := .Request.Headers }}
+			{{range $k, $v := .Response.Headers }}
+			<li><strong>{{$k}}</strong>: <span>{{$v}}</span></li>
+			{{end}}
+		</ul>
+	</dd>
+	<dt>Response body ({{.Response.Size}} bytes{{ if .Response.Truncated }}, truncated{{ end }}{{ with .Response.ContentEncoding }}, {{.}} decoded at {{printf "%.1f" $.Response.CompressionRatio}}x{{ end }})</dt>
+	<dd>{{ template "body" (body .Response) }}</dd>
+	{{ if .Response.Trailers }}
+	<dt>Response Trailers</dt>
+	<dd>
+		<ul>
+			{{range $k, $v := .Response.Trailers }}
 			<li><strong>{{$k}}</strong>: <span>{{$v}}</span></li>
 			{{end}}
 		</ul>
 	</dd>
-	<dt>Response body</dt>
-	<dd><pre class="limit-h">{{.Response.Body}}</pre></dd>
+	{{ end }}
+	{{ with .GRPC }}
+	<hr />
+	<dt>gRPC method</dt>
+	<dd>{{.Method}}</dd>
+	<dt>gRPC status</dt>
+	<dd>{{ if .Status }}{{.StatusName}} ({{.Status}}){{ with .Message }}: {{.}}{{ end }}{{ else }}unknown{{ end }}</dd>
+	{{ if .Errors }}
+	<dt>Decoding errors</dt>
+	<dd><ul>{{ range .Errors }}<li>{{.}}</li>{{ end }}</ul></dd>
+	{{ end }}
+	<dt>gRPC request messages</dt>
+	<dd>{{ range .Requests }}<pre class="limit-h">{{printf "%s" .}}</pre>{{ end }}</dd>
+	<dt>gRPC response messages</dt>
+	<dd>{{ range .Responses }}<pre class="limit-h">{{printf "%s" .}}</pre>{{ end }}</dd>
+	{{ end }}
+	{{ if eq .Code 101 }}
+	<hr />
+	<dt>Conversation</dt>
+	<dd><div hx-get="/conversation?source={{.Source}}&rid={{.ID}}" hx-trigger="load, every 1s" hx-swap="innerHTML"></div></dd>
+	{{ end }}
 </dl>
 {{end}}
+
+{{define "body"}}
+{{- if eq .Kind "image" }}<img class="limit-h" src="{{.Image}}" alt="body image" />
+{{- else if eq .Kind "binary" }}<pre class="limit-h">{{.Text}}</pre>{{ if .Clipped }}<small>binary content, only the first bytes are shown</small>{{ end }}
+{{- else if ne .Kind "empty" }}<pre class="limit-h">{{.Text}}</pre>
+{{- end }}
+{{- end}}
+
+{{define "conversation"}}
+<ul class="chat">
+{{ range . -}}
+<li class="{{.Frame.Direction}}" id="frame-{{.ID}}">
+	<small>{{.Frame.Direction}} - {{.Frame.OpcodeName}}, {{.Frame.Length}} bytes{{ if .Frame.Truncated }} (truncated){{ end }} at {{.Start.Format "15:04:05.000"}}</small>
+	{{ template "body" (payload .Frame) }}
+</li>
+{{- else }}
+<li>No frames yet</li>
+{{- end }}
+</ul>
+{{end}}
 `
 )
@@ -4,14 +4,53 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"strings"
+
+	"github.com/andrebq/inspector/internal/auth"
+)
+
+type (
+	// Options changes how the dashboard reaches the management API
+	Options struct {
+		// API is the base URL of the management API,
+		// defaults to DefaultAPI
+		API string
+		// Credential is presented to the management API
+		Credential auth.Credential
+		// Sources lists the management APIs to follow, API and
+		// Credential are only used when it is empty
+		Sources []Source
+	}
 )
 
-func Handler(ctx context.Context) http.Handler {
-	handler := newRoot()
-	go func() {
-		if err := handler.fetchRequests(ctx); err != nil {
-			log.Printf("Error fetching requests: %v", err)
+// DefaultAPI is where the management API listens unless told otherwise
+const DefaultAPI = "http://localhost:8082/"
+
+func Handler(ctx context.Context, opts Options) http.Handler {
+	var sources []*Source
+	for _, s := range opts.Sources {
+		if !strings.HasSuffix(s.API, "/") {
+			s.API += "/"
+		}
+		sources = append(sources, &s)
+	}
+	if len(sources) == 0 {
+		src := &Source{API: opts.API, Credential: opts.Credential}
+		if src.API == "" {
+			src.API = DefaultAPI
+		}
+		if !strings.HasSuffix(src.API, "/") {
+			src.API += "/"
 		}
-	}()
+		sources = append(sources, src)
+	}
+	handler := newRoot(sources...)
+	for _, s := range sources {
+		go func(s *Source) {
+			if err := handler.fetchRequests(ctx, s); err != nil {
+				log.Printf("Error fetching requests from %v: %v", s.API, err)
+			}
+		}(s)
+	}
 	return handler
 }
@@ -1,14 +1,13 @@
 package dashboard
 
 import (
+	"bufio"
 	"bytes"
-	"context"
-	"encoding/json"
-	"errors"
+	"fmt"
 	"html/template"
-	"io"
 	"log"
 	"net/http"
+	"net/textproto"
 	"strconv"
 	"strings"
 	"sync"
@@ -19,21 +18,21 @@ import (
 
 type (
 	rootHandler struct {
-		mux *http.ServeMux
-		api string
+		mux     *http.ServeMux
+		sources []*Source
 
-		events []*manager.IOEvent
+		events []*event
 		lock   sync.RWMutex
 	}
 )
 
 var (
-	tmpl = template.Must(template.New("__root__").Parse(pages))
+	tmpl = template.Must(template.New("__root__").Funcs(funcs).Parse(pages))
 )
 
-func newRoot() *rootHandler {
+func newRoot(sources ...*Source) *rootHandler {
 	r := &rootHandler{
-		api: "http://localhost:8082/",
+		sources: sources,
 	}
 	r.mux = http.NewServeMux()
 	r.mux.HandleFunc("/builtin/htmx.js", r.serveContent("htmx.js", htmxMin))
@@ -44,6 +43,12 @@ func newRoot() *rootHandler {
 	r.mux.HandleFunc("/index", r.index)
 	r.mux.HandleFunc("/requests", r.requests)
 	r.mux.HandleFunc("/inspect-request", r.inspectRequest)
+	r.mux.HandleFunc("/conversation", r.conversation)
+	r.mux.HandleFunc("/replay", r.replay)
+	r.mux.HandleFunc("/compose", r.compose)
+	r.mux.HandleFunc("/held", r.held)
+	r.mux.HandleFunc("/held-item", r.heldItem)
+	r.mux.HandleFunc("/breakpoints", r.breakpoints)
 	r.mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 		if req.URL.Path != "/" {
 			http.NotFound(w, req)
@@ -68,19 +73,39 @@ func (r *rootHandler) renderTemplate(w http.ResponseWriter, req *http.Request, n
 
 func (r *rootHandler) requests(w http.ResponseWriter, req *http.Request) {
 	type item struct {
-		Code int
-		URL  string
-		ID   int64
+		Source     string
+		Code       int
+		Method     string
+		URL        string
+		ID         int64
+		Start      time.Time
+		Elapsed    manager.Duration
+		RemoteAddr string
+		Proto      string
+		Tags       []string
+		Faults     []manager.Fault
 	}
 
 	acc := []item{}
 	r.lock.RLock()
 	{
 		for _, ev := range r.events {
+			if ev.Frame != nil {
+				// frames are shown in the conversation of their upgrade request
+				continue
+			}
 			acc = append(acc, item{
-				Code: ev.Code,
-				URL:  ev.URL,
-				ID:   ev.ID,
+				Source:     ev.Source,
+				Code:       ev.Code,
+				Method:     ev.Method,
+				URL:        ev.URL,
+				ID:         ev.ID,
+				Start:      ev.Start,
+				Elapsed:    ev.Elapsed(),
+				RemoteAddr: ev.RemoteAddr,
+				Proto:      ev.Proto,
+				Tags:       ev.Tags,
+				Faults:     ev.Faults,
 			})
 		}
 	}
@@ -89,9 +114,12 @@ func (r *rootHandler) requests(w http.ResponseWriter, req *http.Request) {
 	r.renderTemplate(w, req, "requests.html", "requests", struct {
 		Title    string
 		Requests []item
+		// Labeled is set when there are many sources to tell apart
+		Labeled bool
 	}{
 		Title:    "Requests",
 		Requests: acc,
+		Labeled:  len(r.sources) > 1,
 	})
 }
 
@@ -100,22 +128,188 @@ func (r *rootHandler) index(w http.ResponseWriter, req *http.Request) {
 }
 
 func (r *rootHandler) inspectRequest(w http.ResponseWriter, req *http.Request) {
+	src, err := r.source(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	id, err := strconv.ParseInt(req.FormValue("rid"), 10, 64)
 	if err != nil {
 		http.Error(w, "invalid request id", http.StatusBadRequest)
 		return
 	}
-	var ev *manager.IOEvent
+	ev := r.findEvent(src.Label, id)
+	if ev == nil {
+		// might be an old event the proxy still keeps around
+		ev, err = src.fetchEvent(req.Context(), id)
+		if err != nil {
+			log.Printf("Unable to fetch event %v: %v", id, err)
+		}
+	}
+	if ev == nil {
+		http.Error(w, "request id not found", http.StatusNoContent)
+		return
+	}
+	r.renderTemplate(w, req, "inspect-request.html", "inspect-request", ev)
+}
+
+// conversation lists the WebSocket frames of the rid upgrade request, oldest first
+func (r *rootHandler) conversation(w http.ResponseWriter, req *http.Request) {
+	src, err := r.source(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(req.FormValue("rid"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid request id", http.StatusBadRequest)
+		return
+	}
+	frames := []*event{}
 	r.lock.RLock()
-	for _, i := range r.events {
-		if i.ID == id {
-			ev = i
-			break
+	for i := len(r.events) - 1; i >= 0; i-- {
+		if ev := r.events[i]; ev.Source == src.Label && ev.Parent == id && ev.Frame != nil {
+			frames = append(frames, ev)
 		}
 	}
 	r.lock.RUnlock()
+	r.renderTemplate(w, req, "conversation.html", "conversation", frames)
+}
+
+func (r *rootHandler) findEvent(source string, id int64) *event {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, i := range r.events {
+		if i.Source == source && i.ID == id {
+			return i
+		}
+	}
+	return nil
+}
+
+func (r *rootHandler) replay(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "replay requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	src, err := r.source(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(req.FormValue("rid"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid request id", http.StatusBadRequest)
+		return
+	}
+	ev, err := src.callAPI(req.Context(), "POST", fmt.Sprintf("events/%v/replay", id), nil)
+	if err != nil {
+		log.Printf("Unable to replay event %v: %v", id, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
 	if ev == nil {
-		http.Error(w, "request id not found", http.StatusNoContent)
+		http.Error(w, "request id not found", http.StatusNotFound)
+		return
+	}
+	r.renderTemplate(w, req, "inspect-request.html", "inspect-request", ev)
+}
+
+// compose renders the request composer on GET (based on the rid event, when
+// given) and sends the composed request to the proxy on POST
+func (r *rootHandler) compose(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodPost {
+		r.sendComposition(w, req)
+		return
+	}
+	src, err := r.source(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c := manager.Composition{Method: http.MethodGet, URL: "/"}
+	if rid := req.FormValue("rid"); rid != "" {
+		id, err := strconv.ParseInt(rid, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid request id", http.StatusBadRequest)
+			return
+		}
+		ev := r.findEvent(src.Label, id)
+		if ev == nil {
+			ev, err = src.fetchEvent(req.Context(), id)
+			if err != nil {
+				log.Printf("Unable to fetch event %v: %v", id, err)
+			}
+		}
+		if ev == nil {
+			http.Error(w, "request id not found", http.StatusNotFound)
+			return
+		}
+		c = manager.Composition{
+			Method:  ev.Method,
+			URL:     ev.URL,
+			Host:    ev.Host,
+			Headers: ev.Request.Headers,
+			Body:    string(ev.Request.Body),
+			From:    ev.ID,
+		}
+	}
+	r.renderTemplate(w, req, "compose.html", "compose", struct {
+		manager.Composition
+		HeaderText string
+		Source     string
+		Sources    []string
+	}{
+		Composition: c,
+		HeaderText:  formatHeaders(c.Headers),
+		Source:      src.Label,
+		Sources:     r.labels(),
+	})
+}
+
+func formatHeaders(h http.Header) string {
+	buf := &bytes.Buffer{}
+	h.Write(buf)
+	return buf.String()
+}
+
+// parseHeaders reads headers typed by the user, which use
+// the same format as the wire
+func parseHeaders(text string) (http.Header, error) {
+	tp := textproto.NewReader(bufio.NewReader(strings.NewReader(strings.TrimSpace(text) + "\r\n\r\n")))
+	headers, err := tp.ReadMIMEHeader()
+	return http.Header(headers), err
+}
+
+func (r *rootHandler) sendComposition(w http.ResponseWriter, req *http.Request) {
+	src, err := r.source(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	headers, err := parseHeaders(req.FormValue("headers"))
+	if err != nil {
+		http.Error(w, "invalid headers: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	c := manager.Composition{
+		Method:  strings.ToUpper(strings.TrimSpace(req.FormValue("method"))),
+		URL:     strings.TrimSpace(req.FormValue("url")),
+		Host:    strings.TrimSpace(req.FormValue("host")),
+		Headers: headers,
+		Body:    req.FormValue("body"),
+	}
+	if from := req.FormValue("from"); from != "" {
+		c.From, err = strconv.ParseInt(from, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid request id", http.StatusBadRequest)
+			return
+		}
+	}
+	ev, err := src.callAPI(req.Context(), "POST", "compose", c)
+	if err != nil {
+		log.Printf("Unable to send composed request: %v", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
 		return
 	}
 	r.renderTemplate(w, req, "inspect-request.html", "inspect-request", ev)
@@ -129,50 +323,19 @@ func (r *rootHandler) serveContent(name, content string) func(w http.ResponseWri
 
 func (r *rootHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	log.Printf("%v %v", req.Method, req.URL)
+	if !safeMethod(req.Method) && req.Header.Get("HX-Request") != "true" {
+		// a cross-site form cannot set custom headers and browsers send cached
+		// basic auth along with it, so only htmx may change anything
+		http.Error(w, "changes must be made from the dashboard", http.StatusForbidden)
+		return
+	}
 	r.mux.ServeHTTP(w, req)
 }
 
-func (r *rootHandler) fetchRequests(ctx context.Context) error {
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	timeout := time.Second * 5
-
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
-		req, err := http.NewRequestWithContext(ctx, "GET", r.api, nil)
-		if err != nil {
-			return err
-		}
-		res, err := http.DefaultClient.Do(req)
-		if err != nil {
-			log.Printf("Error establishing connection to inspector proxy api: %v", err)
-			<-time.After(timeout)
-			continue
-		}
-		if res.StatusCode != http.StatusOK {
-			log.Printf("Unexpected response from server [%v - %v]", res.StatusCode, res.Status)
-			<-time.After(timeout)
-			continue
-		}
-		log.Printf("Connection with upstram server %v established", r.api)
-		defer res.Body.Close()
-		dec := json.NewDecoder(res.Body)
-		for dec.More() {
-			var out manager.IOEvent
-			if err = dec.Decode(&out); errors.Is(err, io.EOF) {
-				log.Printf("EOF from inspector proxy")
-			} else if err != nil {
-				log.Printf("Unexpected error form inspector proxy: %v", err)
-			}
-			r.lock.Lock()
-			r.events = append([]*manager.IOEvent{&out}, r.events...)
-			r.lock.Unlock()
-		}
-		<-time.After(timeout)
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
 	}
+	return false
 }
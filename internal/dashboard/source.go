@@ -0,0 +1,223 @@
+package dashboard
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andrebq/inspector/internal/auth"
+	"github.com/andrebq/inspector/internal/manager"
+)
+
+type (
+	// Source is a management API followed by the dashboard, the events
+	// of every source are merged into one timeline
+	Source struct {
+		// Label tells events from different sources apart
+		Label string
+		// API is the base URL of the management API
+		API string
+		// Credential is presented to the management API
+		Credential auth.Credential
+	}
+
+	// event is an IOEvent along with the label of its source,
+	// IDs are only unique within a source
+	event struct {
+		*manager.IOEvent
+		Source string
+	}
+)
+
+// ParseSource reads a source in the form [label=]url, the label
+// defaults to the host of url. A url pointing to the request stream
+// is taken as the base of the management API
+func ParseSource(spec string) (Source, error) {
+	var s Source
+	s.API = spec
+	if idx := strings.Index(spec, "="); idx > 0 && !strings.ContainsAny(spec[:idx], ":/") {
+		s.Label, s.API = spec[:idx], spec[idx+1:]
+	}
+	u, err := url.Parse(s.API)
+	if err != nil {
+		return s, fmt.Errorf("invalid endpoint %q: %w", spec, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return s, fmt.Errorf("invalid endpoint %q: expecting an http(s) URL", spec)
+	}
+	if s.Label == "" {
+		s.Label = u.Host
+	}
+	// older releases documented the stream itself as the endpoint
+	u.Path = strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/request-stream") + "/"
+	u.RawPath = ""
+	s.API = u.String()
+	return s, nil
+}
+
+// source returns the Source named by the source form value,
+// the first one when none is given
+func (r *rootHandler) source(req *http.Request) (*Source, error) {
+	label := req.FormValue("source")
+	if label == "" {
+		return r.sources[0], nil
+	}
+	for _, s := range r.sources {
+		if s.Label == label {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown source %q", label)
+}
+
+// labels lists the label of every source, in the order they were given
+func (r *rootHandler) labels() []string {
+	out := make([]string, 0, len(r.sources))
+	for _, s := range r.sources {
+		out = append(out, s.Label)
+	}
+	return out
+}
+
+// addEvent keeps events sorted by start time, newest first,
+// sources are not guaranteed to deliver them in order
+func (r *rootHandler) addEvent(ev *event) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	i := sort.Search(len(r.events), func(i int) bool {
+		return r.events[i].Start.Before(ev.Start)
+	})
+	r.events = append(r.events, nil)
+	copy(r.events[i+1:], r.events[i:])
+	r.events[i] = ev
+}
+
+// fetchEvent asks the management API for a single event,
+// returns nil when the event does not exist
+func (s *Source) fetchEvent(ctx context.Context, id int64) (*event, error) {
+	return s.callAPI(ctx, "GET", fmt.Sprintf("events/%v", id), nil)
+}
+
+// callAPI sends a request (with body encoded as JSON, when present) to the
+// management API and decodes the event it returns, a missing event is
+// reported as nil
+func (s *Source) callAPI(ctx context.Context, method, path string, body any) (*event, error) {
+	var ev manager.IOEvent
+	found, err := s.apiCall(ctx, method, path, body, &ev)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &event{IOEvent: &ev, Source: s.Label}, nil
+}
+
+// apiCall sends a request (with body encoded as JSON, when present) to the
+// management API and decodes its response into out, unless out is nil or
+// the API returned no content. A 404 is reported as not found
+func (s *Source) apiCall(ctx context.Context, method, path string, body, out any) (bool, error) {
+	var payload io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return false, err
+		}
+		payload = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.API+path, payload)
+	if err != nil {
+		return false, err
+	}
+	if body != nil || method == http.MethodPost {
+		// the management API only accepts state changes sent as JSON
+		req.Header.Set("Content-Type", "application/json")
+	}
+	s.Credential.Apply(req)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return false, fmt.Errorf("unexpected response from server [%v - %v]: %s", res.StatusCode, res.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return true, nil
+	}
+	return true, json.NewDecoder(res.Body).Decode(out)
+}
+
+// streamURL points to the request stream of s asking only for events
+// published after the ones already received
+func (r *rootHandler) streamURL(s *Source) string {
+	since := int64(0)
+	r.lock.RLock()
+	for _, ev := range r.events {
+		if ev.Source == s.Label && ev.Seq > since {
+			since = ev.Seq
+		}
+	}
+	r.lock.RUnlock()
+	return fmt.Sprintf("%vrequest-stream?since=%v", s.API, since)
+}
+
+func (r *rootHandler) fetchRequests(ctx context.Context, s *Source) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	timeout := time.Second * 5
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", r.streamURL(s), nil)
+		if err != nil {
+			return err
+		}
+		s.Credential.Apply(req)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Printf("Error establishing connection to inspector proxy api %v: %v", s.Label, err)
+			<-time.After(timeout)
+			continue
+		}
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			log.Printf("Unexpected response from server %v [%v - %v]", s.Label, res.StatusCode, res.Status)
+			<-time.After(timeout)
+			continue
+		}
+		log.Printf("Connection with upstram server %v (%v) established", s.Label, s.API)
+		dec := json.NewDecoder(res.Body)
+		for dec.More() {
+			var out manager.IOEvent
+			if err = dec.Decode(&out); errors.Is(err, io.EOF) {
+				log.Printf("EOF from inspector proxy %v", s.Label)
+				break
+			} else if err != nil {
+				log.Printf("Unexpected error form inspector proxy %v: %v", s.Label, err)
+				break
+			}
+			r.addEvent(&event{IOEvent: &out, Source: s.Label})
+		}
+		res.Body.Close()
+		<-time.After(timeout)
+	}
+}
@@ -0,0 +1,31 @@
+package dashboard
+
+import "testing"
+
+func TestParseSource(t *testing.T) {
+	tests := []struct {
+		spec  string
+		label string
+		api   string
+		err   bool
+	}{
+		{spec: "http://localhost:8082", label: "localhost:8082", api: "http://localhost:8082/"},
+		{spec: "http://localhost:8082/", label: "localhost:8082", api: "http://localhost:8082/"},
+		{spec: "http://localhost:8082/request-stream", label: "localhost:8082", api: "http://localhost:8082/"},
+		{spec: "gw=http://10.0.0.5:8082/proxy/request-stream/", label: "gw", api: "http://10.0.0.5:8082/proxy/"},
+		{spec: "gw=http://10.0.0.5:8082/proxy", label: "gw", api: "http://10.0.0.5:8082/proxy/"},
+		{spec: "http://h/a=b", label: "h", api: "http://h/a=b/"},
+		{spec: "gw=localhost:8082", err: true},
+		{spec: "ftp://localhost", err: true},
+	}
+	for _, tt := range tests {
+		s, err := ParseSource(tt.spec)
+		if (err != nil) != tt.err {
+			t.Errorf("%v: got error %v, want error %v", tt.spec, err, tt.err)
+			continue
+		}
+		if !tt.err && (s.Label != tt.label || s.API != tt.api) {
+			t.Errorf("%v: got %v %v, want %v %v", tt.spec, s.Label, s.API, tt.label, tt.api)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Package grpcjson converts gRPC messages to JSON using the
+// descriptors of the services that produced them
+package grpcjson
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+type (
+	// Decoder knows every service in a FileDescriptorSet
+	Decoder struct {
+		files *protoregistry.Files
+		types *dynamicpb.Types
+	}
+)
+
+// Load reads a FileDescriptorSet, as produced by
+// protoc --include_imports --descriptor_set_out=file
+func Load(file string) (*Decoder, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(buf, &set); err != nil {
+		return nil, fmt.Errorf("unable to parse descriptor set %v: %w", file, err)
+	}
+	return New(&set)
+}
+
+// New returns a decoder for the services in set
+func New(set *descriptorpb.FileDescriptorSet) (*Decoder, error) {
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %w", err)
+	}
+	return &Decoder{files: files, types: dynamicpb.NewTypes(files)}, nil
+}
+
+// DecodeRequest converts a request message of method (eg.: /pkg.Service/Method) to JSON
+func (d *Decoder) DecodeRequest(method string, msg []byte) (json.RawMessage, error) {
+	md, err := d.method(method)
+	if err != nil {
+		return nil, err
+	}
+	return d.decode(md.Input(), msg)
+}
+
+// DecodeResponse converts a response message of method (eg.: /pkg.Service/Method) to JSON
+func (d *Decoder) DecodeResponse(method string, msg []byte) (json.RawMessage, error) {
+	md, err := d.method(method)
+	if err != nil {
+		return nil, err
+	}
+	return d.decode(md.Output(), msg)
+}
+
+func (d *Decoder) method(path string) (protoreflect.MethodDescriptor, error) {
+	service, method, ok := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid gRPC method %q", path)
+	}
+	desc, err := d.files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("unknown service %q: %w", service, err)
+	}
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a service", service)
+	}
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil, fmt.Errorf("unknown method %q in service %q", method, service)
+	}
+	return md, nil
+}
+
+func (d *Decoder) decode(desc protoreflect.MessageDescriptor, msg []byte) (json.RawMessage, error) {
+	m := dynamicpb.NewMessage(desc)
+	if err := (proto.UnmarshalOptions{Resolver: d.types}).Unmarshal(msg, m); err != nil {
+		return nil, err
+	}
+	return protojson.MarshalOptions{Resolver: d.types}.Marshal(m)
+}
@@ -0,0 +1,114 @@
+// Package har contains the types needed to write HTTP Archive 1.2 files
+//
+// See http://www.softwareishard.com/blog/har-12-spec/
+package har
+
+type (
+	// File is the root object of a HAR document
+	File struct {
+		Log Log `json:"log"`
+	}
+
+	// Log holds every exported entry
+	Log struct {
+		Version string  `json:"version"`
+		Creator Creator `json:"creator"`
+		Entries []Entry `json:"entries"`
+	}
+
+	// Creator identifies the application that wrote the file
+	Creator struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+
+	// Entry is a single request/response pair
+	Entry struct {
+		StartedDateTime string   `json:"startedDateTime"`
+		Time            float64  `json:"time"`
+		Request         Request  `json:"request"`
+		Response        Response `json:"response"`
+		Cache           struct{} `json:"cache"`
+		Timings         Timings  `json:"timings"`
+		ServerIPAddress string   `json:"serverIPAddress,omitempty"`
+		Comment         string   `json:"comment,omitempty"`
+		// WebSocketMessages follows the extension used by Chrome DevTools
+		WebSocketMessages []WebSocketMessage `json:"_webSocketMessages,omitempty"`
+	}
+
+	// WebSocketMessage is a single frame, Type is either send or receive
+	// and Time is in seconds since the unix epoch
+	WebSocketMessage struct {
+		Type   string  `json:"type"`
+		Time   float64 `json:"time"`
+		Opcode int     `json:"opcode"`
+		Data   string  `json:"data"`
+	}
+
+	Request struct {
+		Method      string      `json:"method"`
+		URL         string      `json:"url"`
+		HTTPVersion string      `json:"httpVersion"`
+		Cookies     []Cookie    `json:"cookies"`
+		Headers     []NameValue `json:"headers"`
+		QueryString []NameValue `json:"queryString"`
+		PostData    *PostData   `json:"postData,omitempty"`
+		HeadersSize int64       `json:"headersSize"`
+		BodySize    int64       `json:"bodySize"`
+		Comment     string      `json:"comment,omitempty"`
+	}
+
+	Response struct {
+		Status      int         `json:"status"`
+		StatusText  string      `json:"statusText"`
+		HTTPVersion string      `json:"httpVersion"`
+		Cookies     []Cookie    `json:"cookies"`
+		Headers     []NameValue `json:"headers"`
+		Content     Content     `json:"content"`
+		RedirectURL string      `json:"redirectURL"`
+		HeadersSize int64       `json:"headersSize"`
+		BodySize    int64       `json:"bodySize"`
+		Comment     string      `json:"comment,omitempty"`
+	}
+
+	Cookie struct {
+		Name     string `json:"name"`
+		Value    string `json:"value"`
+		Path     string `json:"path,omitempty"`
+		Domain   string `json:"domain,omitempty"`
+		Expires  string `json:"expires,omitempty"`
+		HTTPOnly bool   `json:"httpOnly,omitempty"`
+		Secure   bool   `json:"secure,omitempty"`
+	}
+
+	NameValue struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+
+	PostData struct {
+		MimeType string `json:"mimeType"`
+		Text     string `json:"text"`
+		Comment  string `json:"comment,omitempty"`
+	}
+
+	Content struct {
+		Size        int64  `json:"size"`
+		Compression int64  `json:"compression,omitempty"`
+		MimeType    string `json:"mimeType"`
+		Text        string `json:"text,omitempty"`
+		Encoding    string `json:"encoding,omitempty"`
+	}
+
+	// Timings are expressed in milliseconds, optional fields
+	// use -1 when the information is not available
+	Timings struct {
+		Blocked float64 `json:"blocked"`
+		DNS     float64 `json:"dns"`
+		Connect float64 `json:"connect"`
+		Send    float64 `json:"send"`
+		Wait    float64 `json:"wait"`
+		Receive float64 `json:"receive"`
+		SSL     float64 `json:"ssl"`
+	}
+)
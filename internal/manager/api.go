@@ -0,0 +1,323 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// events lists, as JSON lines, the events matching the query
+// described by the request parameters (see ParseQuery)
+func (m *M) events(w http.ResponseWriter, req *http.Request) {
+	q, err := ParseQuery(req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	events, err := m.query(q)
+	if err != nil {
+		log.Printf("Error querying events: %v", err)
+		http.Error(w, "unable to query events", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	for _, ev := range events {
+		if writeEvent(w, ev) != nil {
+			return
+		}
+	}
+}
+
+// exportHAR writes the events matching the query (see ParseQuery)
+// as an HAR file
+func (m *M) exportHAR(w http.ResponseWriter, req *http.Request) {
+	q, err := ParseQuery(req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	events, err := m.query(q)
+	if err != nil {
+		log.Printf("Error querying events: %v", err)
+		http.Error(w, "unable to query events", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="inspector.har"`)
+	json.NewEncoder(w).Encode(HAR(events))
+}
+
+// compose sends the request described by the Composition in the
+// body to the upstream
+func (m *M) compose(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "compose requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireJSON(w, req) {
+		return
+	}
+	var c Composition
+	if err := json.NewDecoder(req.Body).Decode(&c); err != nil {
+		http.Error(w, "invalid composition: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	ev, err := m.Compose(req.Context(), c)
+	if errors.Is(err, ErrRedacted) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, ev)
+}
+
+// event handles /events/{id} and /events/{id}/replay
+func (m *M) event(w http.ResponseWriter, req *http.Request) {
+	rawID, action, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/events/"), "/")
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid event id", http.StatusBadRequest)
+		return
+	}
+	var ev *IOEvent
+	switch action {
+	case "":
+		ev, err = m.lookup(id)
+	case "replay":
+		if req.Method != http.MethodPost {
+			http.Error(w, "replay requires POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if !requireJSON(w, req) {
+			return
+		}
+		ev, err = m.Replay(req.Context(), id)
+	default:
+		http.NotFound(w, req)
+		return
+	}
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.NotFound(w, req)
+		return
+	case errors.Is(err, ErrTruncatedBody), errors.Is(err, ErrRedacted):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Error handling event %v: %v", id, err)
+		http.Error(w, "unable to handle event", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, ev)
+}
+
+// breakpointsAPI lists (GET) or adds (POST) breakpoints on /breakpoints,
+// while /breakpoints/{id} removes (DELETE) one of them
+func (m *M) breakpointsAPI(w http.ResponseWriter, req *http.Request) {
+	if rawID := strings.TrimPrefix(req.URL.Path, "/breakpoints/"); rawID != req.URL.Path {
+		id, err := strconv.ParseInt(rawID, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid breakpoint id", http.StatusBadRequest)
+			return
+		}
+		if req.Method != http.MethodDelete {
+			http.Error(w, "breakpoints can only be removed", http.StatusMethodNotAllowed)
+			return
+		}
+		if m.RemoveBreakpoint(id) != nil {
+			http.NotFound(w, req)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(w, m.Breakpoints())
+	case http.MethodPost:
+		if !requireJSON(w, req) {
+			return
+		}
+		var b Breakpoint
+		if err := json.NewDecoder(req.Body).Decode(&b); err != nil {
+			http.Error(w, "invalid breakpoint: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		b, err := m.AddBreakpoint(b)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, b)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// heldAPI lists the exchanges waiting for a decision on /held, while
+// POST /held/{id} releases one of them using the Decision in the body
+func (m *M) heldAPI(w http.ResponseWriter, req *http.Request) {
+	rawID := strings.TrimPrefix(req.URL.Path, "/held/")
+	if rawID == req.URL.Path {
+		writeJSON(w, m.HeldExchanges())
+		return
+	}
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid event id", http.StatusBadRequest)
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, "decisions require POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireJSON(w, req) {
+		return
+	}
+	var d Decision
+	if err := json.NewDecoder(req.Body).Decode(&d); err != nil {
+		http.Error(w, "invalid decision: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = m.Decide(id, d)
+	if errors.Is(err, ErrNotHeld) {
+		http.NotFound(w, req)
+		return
+	} else if errors.Is(err, ErrRedacted) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// mocksAPI lists (GET) or adds (POST) mock rules on /mocks,
+// while /mocks/{id} removes (DELETE) one of them
+func (m *M) mocksAPI(w http.ResponseWriter, req *http.Request) {
+	if rawID := strings.TrimPrefix(req.URL.Path, "/mocks/"); rawID != req.URL.Path {
+		id, err := strconv.ParseInt(rawID, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid mock id", http.StatusBadRequest)
+			return
+		}
+		if req.Method != http.MethodDelete {
+			http.Error(w, "mocks can only be removed", http.StatusMethodNotAllowed)
+			return
+		}
+		if m.RemoveMock(id) != nil {
+			http.NotFound(w, req)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(w, m.Mocks())
+	case http.MethodPost:
+		if !requireJSON(w, req) {
+			return
+		}
+		var r MockRule
+		if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+			http.Error(w, "invalid mock: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		r, err := m.AddMock(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// faultsAPI lists (GET) or replaces (PUT) the fault rules
+func (m *M) faultsAPI(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(w, m.Faults())
+	case http.MethodPut:
+		if !requireJSON(w, req) {
+			return
+		}
+		var rules []FaultRule
+		if err := json.NewDecoder(req.Body).Decode(&rules); err != nil {
+			http.Error(w, "invalid fault rules: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := m.SetFaults(rules); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, m.Faults())
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// requireJSON rejects state changes not sent as application/json, which
+// browsers only send cross-site after a CORS preflight that is never
+// answered, so other sites cannot drive the API (even an empty replay)
+func requireJSON(w http.ResponseWriter, req *http.Request) bool {
+	mt, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mt != "application/json" {
+		http.Error(w, "expecting an application/json request", http.StatusUnsupportedMediaType)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// lookup finds an event either in the history or in the store
+func (m *M) lookup(id int64) (*IOEvent, error) {
+	m.lock.RLock()
+	if m.history != nil {
+		for _, ev := range m.history.since(0) {
+			if ev.ID == id {
+				m.lock.RUnlock()
+				return ev, nil
+			}
+		}
+	}
+	m.lock.RUnlock()
+	if m.Store == nil {
+		return nil, ErrNotFound
+	}
+	return m.Store.Get(id)
+}
+
+// query prefers the store, since it has a longer memory than the history
+func (m *M) query(q Query) ([]*IOEvent, error) {
+	if m.Store != nil {
+		return m.Store.Query(q)
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if m.history == nil {
+		return nil, nil
+	}
+	var out []*IOEvent
+	for _, ev := range m.history.since(0) {
+		if q.Match(ev) {
+			out = append(out, ev)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return q.Trim(out), nil
+}
@@ -0,0 +1,156 @@
+package manager
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// EncodingText and EncodingBase64 tell how bodies are written in JSON,
+	// binary content is always base64 encoded
+	EncodingText   = "text"
+	EncodingBase64 = "base64"
+)
+
+// isText reports if body can be shown as text, contentType helps to spot
+// binary formats that happen to be valid UTF-8
+func isText(contentType string, body []byte) bool {
+	if !utf8.Valid(body) {
+		return false
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mt == "" {
+		return true
+	}
+	switch {
+	case strings.HasPrefix(mt, "text/"),
+		strings.HasSuffix(mt, "json"), strings.HasSuffix(mt, "xml"),
+		strings.Contains(mt, "javascript"),
+		mt == "application/x-www-form-urlencoded":
+		return true
+	case strings.HasPrefix(mt, "image/"), strings.HasPrefix(mt, "audio/"),
+		strings.HasPrefix(mt, "video/"), strings.HasPrefix(mt, "font/"),
+		strings.HasPrefix(mt, "application/grpc"), strings.Contains(mt, "protobuf"),
+		mt == "application/octet-stream", mt == "application/zip", mt == "application/gzip",
+		mt == "application/pdf":
+		return false
+	}
+	return true
+}
+
+func encodeBody(text bool, body []byte) (string, string) {
+	if text {
+		return string(body), EncodingText
+	}
+	return base64.StdEncoding.EncodeToString(body), EncodingBase64
+}
+
+func decodeBody(encoding, body string) ([]byte, error) {
+	if encoding == EncodingBase64 {
+		return base64.StdEncoding.DecodeString(body)
+	}
+	return []byte(body), nil
+}
+
+// ContentType of the message, taken from its headers
+func (m Message) ContentType() string {
+	return m.Headers.Get("Content-Type")
+}
+
+// IsText reports if the body can be shown as text
+func (m Message) IsText() bool {
+	return isText(m.ContentType(), m.Body)
+}
+
+// Content is the body as it should be shown, without its Content-Encoding
+// when it could be decoded
+func (m Message) Content() []byte {
+	if m.Decoded != nil {
+		return m.Decoded
+	}
+	return m.Body
+}
+
+// ContentIsText reports if Content can be shown as text
+func (m Message) ContentIsText() bool {
+	return isText(m.ContentType(), m.Content())
+}
+
+func (m Message) MarshalJSON() ([]byte, error) {
+	type message Message
+	body, enc := encodeBody(m.IsText(), m.Body)
+	aux := struct {
+		message
+		Body            string `json:"body"`
+		Encoding        string `json:"encoding"`
+		Decoded         string `json:"decoded,omitempty"`
+		DecodedEncoding string `json:"decodedEncoding,omitempty"`
+	}{message: message(m), Body: body, Encoding: enc}
+	if m.Decoded != nil {
+		aux.Decoded, aux.DecodedEncoding = encodeBody(isText(m.ContentType(), m.Decoded), m.Decoded)
+	}
+	return json.Marshal(aux)
+}
+
+// UnmarshalJSON accepts bodies without an encoding as text,
+// which is how events were written before
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type message Message
+	aux := struct {
+		*message
+		Body            string `json:"body"`
+		Encoding        string `json:"encoding"`
+		Decoded         string `json:"decoded"`
+		DecodedEncoding string `json:"decodedEncoding"`
+	}{message: (*message)(m)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	var err error
+	if m.Body, err = decodeBody(aux.Encoding, aux.Body); err != nil {
+		return err
+	}
+	if aux.Decoded != "" {
+		m.Decoded, err = decodeBody(aux.DecodedEncoding, aux.Decoded)
+	}
+	return err
+}
+
+// IsText reports if the payload can be shown as text
+func (f *Frame) IsText() bool {
+	switch f.Opcode {
+	case 0x1:
+		return utf8.Valid(f.Payload)
+	case 0x2:
+		return false
+	}
+	return isText("", f.Payload)
+}
+
+func (f Frame) MarshalJSON() ([]byte, error) {
+	type frame Frame
+	payload, enc := encodeBody(f.IsText(), f.Payload)
+	return json.Marshal(struct {
+		frame
+		Payload  string `json:"payload"`
+		Encoding string `json:"encoding"`
+	}{frame: frame(f), Payload: payload, Encoding: enc})
+}
+
+func (f *Frame) UnmarshalJSON(data []byte) error {
+	type frame Frame
+	aux := struct {
+		*frame
+		Payload  string `json:"payload"`
+		Encoding string `json:"encoding"`
+	}{frame: (*frame)(f)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	var err error
+	f.Payload, err = decodeBody(aux.Encoding, aux.Payload)
+	return err
+}
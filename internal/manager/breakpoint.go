@@ -0,0 +1,367 @@
+package manager
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PhaseRequest holds requests before they are sent upstream
+	PhaseRequest = "request"
+	// PhaseResponse holds responses before they are sent to the client
+	PhaseResponse = "response"
+
+	// ActionApprove releases the exchange without changes
+	ActionApprove = "approve"
+	// ActionEdit releases the exchange after applying the decision changes
+	ActionEdit = "edit"
+	// ActionDrop answers the client with an error instead
+	ActionDrop = "drop"
+
+	// TagIntercepted marks events held by a breakpoint
+	TagIntercepted = "intercepted"
+	// TagEdited marks events changed while held
+	TagEdited = "edited"
+	// TagDropped marks events dropped while held
+	TagDropped = "dropped"
+)
+
+var (
+	// ErrNotHeld is returned when deciding on an exchange that is not held
+	ErrNotHeld = errors.New("exchange is not being held")
+)
+
+type (
+	// Breakpoint holds every exchange matching it until someone decides
+	// what to do with it, empty fields match anything
+	Breakpoint struct {
+		ID     int64  `json:"id"`
+		Phase  string `json:"phase"`
+		Method string `json:"method,omitempty"`
+		// Path is a regular expression matched against the request path
+		Path string `json:"path,omitempty"`
+
+		path *regexp.Regexp
+	}
+
+	// Held is an exchange waiting for a decision, Event contains
+	// what is known so far (the response is only available on
+	// the response phase)
+	Held struct {
+		Phase      string   `json:"phase"`
+		Breakpoint int64    `json:"breakpoint"`
+		Event      *IOEvent `json:"event"`
+
+		decision chan Decision
+		// orig keeps the URL and the body of the held phase
+		// before redaction, it is nil when nothing was hidden
+		orig *secret
+	}
+
+	// Decision releases an held exchange, only ActionEdit uses
+	// the remaining fields, empty ones are left unchanged
+	Decision struct {
+		Action string `json:"action"`
+		// Method and URL only apply to the request phase
+		Method string `json:"method,omitempty"`
+		URL    string `json:"url,omitempty"`
+		// Code only applies to the response phase
+		Code    int         `json:"code,omitempty"`
+		Headers http.Header `json:"headers,omitempty"`
+		Body    *string     `json:"body,omitempty"`
+	}
+)
+
+// ParseBreakpoint reads a breakpoint in the form phase:method:path,
+// where method and path might be empty (eg.: request::^/api or response:POST:)
+func ParseBreakpoint(spec string) (Breakpoint, error) {
+	parts := strings.SplitN(spec, ":", 3)
+	if len(parts) != 3 {
+		return Breakpoint{}, fmt.Errorf("invalid breakpoint %q, expecting phase:method:path", spec)
+	}
+	return Breakpoint{Phase: parts[0], Method: parts[1], Path: parts[2]}, nil
+}
+
+func (b *Breakpoint) compile() error {
+	b.Phase = strings.ToLower(b.Phase)
+	if b.Phase != PhaseRequest && b.Phase != PhaseResponse {
+		return fmt.Errorf("invalid breakpoint phase %q, expecting %v or %v", b.Phase, PhaseRequest, PhaseResponse)
+	}
+	b.Method = strings.ToUpper(b.Method)
+	if b.Method == "*" {
+		b.Method = ""
+	}
+	if b.Path == "" {
+		return nil
+	}
+	var err error
+	b.path, err = regexp.Compile(b.Path)
+	return err
+}
+
+func (b *Breakpoint) match(phase string, req *http.Request) bool {
+	if b.Phase != phase {
+		return false
+	}
+	if b.Method != "" && b.Method != req.Method {
+		return false
+	}
+	return b.path == nil || b.path.MatchString(req.URL.Path)
+}
+
+// AddBreakpoint starts holding exchanges matching b
+func (m *M) AddBreakpoint(b Breakpoint) (Breakpoint, error) {
+	if err := b.compile(); err != nil {
+		return Breakpoint{}, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.bpcount++
+	b.ID = m.bpcount
+	m.breakpoints = append(m.breakpoints, b)
+	return b, nil
+}
+
+// RemoveBreakpoint stops holding exchanges matching the breakpoint, anything
+// already held still waits for a decision
+func (m *M) RemoveBreakpoint(id int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for i, b := range m.breakpoints {
+		if b.ID == id {
+			m.breakpoints = append(m.breakpoints[:i:i], m.breakpoints[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// Breakpoints returns all active breakpoints
+func (m *M) Breakpoints() []Breakpoint {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return append([]Breakpoint{}, m.breakpoints...)
+}
+
+// HeldExchanges returns everything waiting for a decision
+func (m *M) HeldExchanges() []*Held {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	out := []*Held{}
+	for _, id := range sortedIDs(m.held) {
+		out = append(out, m.held[id])
+	}
+	return out
+}
+
+// Decide releases the exchange held for the given event
+func (m *M) Decide(id int64, d Decision) error {
+	switch d.Action {
+	case ActionApprove, ActionEdit, ActionDrop:
+	default:
+		return fmt.Errorf("invalid action %q", d.Action)
+	}
+	m.lock.Lock()
+	h, ok := m.held[id]
+	if !ok {
+		m.lock.Unlock()
+		return ErrNotHeld
+	}
+	if err := h.unredact(&d); err != nil {
+		m.lock.Unlock()
+		return err
+	}
+	delete(m.held, id)
+	m.lock.Unlock()
+	h.decision <- d
+	return nil
+}
+
+// unredact puts back the values hidden from the held exchange when an
+// edit left their placeholders untouched
+func (h *Held) unredact(d *Decision) error {
+	if d.Action != ActionEdit || h.orig == nil {
+		return nil
+	}
+	var err error
+	if d.URL != "" {
+		if d.URL, err = unredact(d.URL, h.orig.publishedURL, h.orig.url); err != nil {
+			return err
+		}
+	}
+	if d.Body != nil {
+		body, err := unredact(*d.Body, string(h.orig.published), string(h.orig.body))
+		if err != nil {
+			return err
+		}
+		d.Body = &body
+	}
+	return nil
+}
+
+func (m *M) matchBreakpoint(phase string, req *http.Request) (int64, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, b := range m.breakpoints {
+		if b.match(phase, req) {
+			return b.ID, true
+		}
+	}
+	return 0, false
+}
+
+// hold blocks until someone decides what to do with the exchange, a client
+// that goes away is handled as a drop
+func (m *M) hold(req *http.Request, phase string, bp int64, snapshot *IOEvent) Decision {
+	h := &Held{Phase: phase, Breakpoint: bp, Event: snapshot, decision: make(chan Decision, 1)}
+	if m.Redact != nil {
+		// held exchanges are visible from the API as well
+		snapshot.Request.Headers = snapshot.Request.Headers.Clone()
+		snapshot.Response.Headers = snapshot.Response.Headers.Clone()
+		msg := &snapshot.Request
+		if phase == PhaseResponse {
+			msg = &snapshot.Response
+		}
+		h.orig = &secret{url: snapshot.URL, body: msg.Body}
+		m.Redact.Apply(snapshot)
+		h.orig.publishedURL, h.orig.published = snapshot.URL, msg.Body
+	}
+	m.lock.Lock()
+	if m.held == nil {
+		m.held = make(map[int64]*Held)
+	}
+	m.held[snapshot.ID] = h
+	m.lock.Unlock()
+
+	select {
+	case d := <-h.decision:
+		return d
+	case <-req.Context().Done():
+		m.lock.Lock()
+		delete(m.held, snapshot.ID)
+		m.lock.Unlock()
+		return Decision{Action: ActionDrop}
+	}
+}
+
+// holdRequest checks for request breakpoints, and when one matches, waits
+// for a decision. It returns false if the request was dropped
+func (m *M) holdRequest(w http.ResponseWriter, req *http.Request, ev *IOEvent, reqBody *limitedBuffer) bool {
+	bp, ok := m.matchBreakpoint(PhaseRequest, req)
+	if !ok {
+		return true
+	}
+	ev.Tags = append(ev.Tags, TagIntercepted)
+	body, _ := io.ReadAll(req.Body)
+	snapshot := *ev
+	snapshot.Request.Body = body
+
+	d := m.hold(req, PhaseRequest, bp, &snapshot)
+	if d.Action == ActionDrop {
+		ev.Tags = append(ev.Tags, TagDropped)
+		http.Error(w, "request dropped by inspector", http.StatusBadGateway)
+		return false
+	}
+	if d.Action == ActionEdit {
+		ev.Tags = append(ev.Tags, TagEdited)
+		if d.Method != "" {
+			req.Method = strings.ToUpper(d.Method)
+			ev.Method = req.Method
+		}
+		if d.URL != "" {
+			if u, err := url.Parse(d.URL); err == nil {
+				req.URL = u
+				ev.URL = u.String()
+			}
+		}
+		if d.Headers != nil {
+			restoreRedacted(d.Headers, req.Header)
+			req.Header = d.Headers.Clone()
+			ev.Request.Headers = d.Headers.Clone()
+		}
+		if d.Body != nil {
+			body = []byte(*d.Body)
+		}
+	}
+	// the capture should reflect what was actually sent upstream
+	reqBody.reset()
+	req.ContentLength = int64(len(body))
+	req.Header.Del("Content-Length")
+	req.Body = &teeBody{ReadCloser: io.NopCloser(bytes.NewReader(body)), capture: reqBody}
+	return true
+}
+
+// serveHeld behaves like the upstream, but when a response breakpoint
+// matches, the whole response is buffered and held until a decision is made
+func (m *M) serveHeld(res http.ResponseWriter, req *http.Request, ev *IOEvent) {
+	bp, ok := m.matchBreakpoint(PhaseResponse, req)
+	// upgraded connections cannot be buffered
+	if !ok || isWebSocket(req) {
+		m.forward(res, req, ev)
+		return
+	}
+	if !hasTag(ev, TagIntercepted) {
+		ev.Tags = append(ev.Tags, TagIntercepted)
+	}
+	rec := httptest.NewRecorder()
+	m.forward(rec, req, ev)
+
+	snapshot := *ev
+	snapshot.Code = rec.Code
+	snapshot.Response.Headers = rec.Header().Clone()
+	snapshot.Response.Body = append([]byte(nil), rec.Body.Bytes()...)
+
+	d := m.hold(req, PhaseResponse, bp, &snapshot)
+	code, headers, body := rec.Code, rec.Header(), rec.Body.Bytes()
+	switch d.Action {
+	case ActionDrop:
+		ev.Tags = append(ev.Tags, TagDropped)
+		http.Error(res, "response dropped by inspector", http.StatusBadGateway)
+		return
+	case ActionEdit:
+		ev.Tags = append(ev.Tags, TagEdited)
+		if d.Code != 0 {
+			code = d.Code
+		}
+		if d.Headers != nil {
+			restoreRedacted(d.Headers, headers)
+			headers = d.Headers
+		}
+		if d.Body != nil {
+			body = []byte(*d.Body)
+		}
+	}
+	for k, vals := range headers {
+		res.Header()[k] = append([]string(nil), vals...)
+	}
+	res.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	res.WriteHeader(code)
+	res.Write(body)
+}
+
+func sortedIDs[T any](m map[int64]T) []int64 {
+	ids := make([]int64, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func hasTag(ev *IOEvent, tag string) bool {
+	for _, t := range ev.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
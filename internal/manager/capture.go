@@ -0,0 +1,154 @@
+package manager
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type (
+	// limitedBuffer keeps at most limit bytes of everything written to it,
+	// while still reporting the whole write as successful
+	limitedBuffer struct {
+		lock      sync.Mutex
+		buf       bytes.Buffer
+		limit     int64
+		truncated bool
+		// total counts every byte written, kept or not
+		total int64
+	}
+
+	// teeBody copies the request body into a limitedBuffer as the upstream reads it
+	teeBody struct {
+		io.ReadCloser
+		capture *limitedBuffer
+	}
+
+	// captureWriter forwards everything to the client as it arrives while
+	// keeping a copy of the status, headers and (limited) body of the response
+	captureWriter struct {
+		http.ResponseWriter
+		code    int
+		header  http.Header
+		trailer http.Header
+		body    *limitedBuffer
+		// wrap, when set, replaces the connection returned by Hijack
+		wrap func(net.Conn, *bufio.ReadWriter) (net.Conn, *bufio.ReadWriter)
+		// done makes sure the event is only reported once
+		done sync.Once
+	}
+)
+
+func newLimitedBuffer(limit int64) *limitedBuffer {
+	return &limitedBuffer{limit: limit}
+}
+
+func (l *limitedBuffer) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	sz := len(p)
+	l.total += int64(sz)
+	room := l.limit - int64(l.buf.Len())
+	if room < 0 {
+		room = 0
+	}
+	if int64(len(p)) > room {
+		l.truncated = true
+		p = p[:room]
+	}
+	l.buf.Write(p)
+	return sz, nil
+}
+
+func (l *limitedBuffer) snapshot() ([]byte, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]byte(nil), l.buf.Bytes()...), l.truncated
+}
+
+func (l *limitedBuffer) reset() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.buf.Reset()
+	l.truncated = false
+	l.total = 0
+}
+
+func (l *limitedBuffer) size() int64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.total
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		t.capture.Write(p[:n])
+	}
+	return n, err
+}
+
+func (c *captureWriter) WriteHeader(code int) {
+	// informational responses (except protocol switches) are not final,
+	// so keep waiting for the real status code
+	if c.code == 0 && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		c.code = code
+		c.header = c.ResponseWriter.Header().Clone()
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *captureWriter) Write(p []byte) (int, error) {
+	if c.code == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	c.body.Write(p)
+	return c.ResponseWriter.Write(p)
+}
+
+func (c *captureWriter) Flush() {
+	http.NewResponseController(c.ResponseWriter).Flush()
+}
+
+// Unwrap allows http.ResponseController to reach the original writer
+func (c *captureWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// Hijack lets upgraded connections (eg.: WebSocket) be captured as well
+func (c *captureWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(c.ResponseWriter).Hijack()
+	if err != nil || c.wrap == nil {
+		return conn, brw, err
+	}
+	conn, brw = c.wrap(conn, brw)
+	return conn, brw, nil
+}
+
+// trailers returns the trailers set on the response, either announced
+// by the Trailer header or using http.TrailerPrefix
+func (c *captureWriter) trailers() http.Header {
+	h := c.ResponseWriter.Header()
+	out := http.Header{}
+	for _, names := range h.Values("Trailer") {
+		for _, name := range strings.Split(names, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if vals, ok := h[name]; ok {
+				out[name] = append([]string(nil), vals...)
+			}
+		}
+	}
+	for k, vals := range h {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			out[http.CanonicalHeaderKey(strings.TrimPrefix(k, http.TrailerPrefix))] = append([]string(nil), vals...)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,70 @@
+package manager
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// decodeContent removes the Content-Encoding of msg.Body into msg.Decoded,
+// the decoded view is capped at limit bytes. Truncated bodies are decoded
+// as far as possible
+func decodeContent(msg *Message, limit int64) error {
+	encoding := strings.TrimSpace(msg.Headers.Get("Content-Encoding"))
+	if encoding == "" || len(msg.Body) == 0 {
+		return nil
+	}
+	var r io.Reader = bytes.NewReader(msg.Body)
+	var applied []string
+	codings := strings.Split(encoding, ",")
+	// encodings are listed in the order they were applied
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		if coding == "" || coding == "identity" {
+			continue
+		}
+		dec, err := decoder(coding, r)
+		if err != nil {
+			return err
+		}
+		r = dec
+		applied = append(applied, coding)
+	}
+	if len(applied) == 0 {
+		return nil
+	}
+	buf := &bytes.Buffer{}
+	_, err := io.Copy(buf, io.LimitReader(r, limit))
+	if err != nil && !(msg.Truncated && errors.Is(err, io.ErrUnexpectedEOF)) {
+		return fmt.Errorf("unable to decode %v body: %w", encoding, err)
+	}
+	msg.Decoded = buf.Bytes()
+	msg.ContentEncoding = strings.Join(applied, ", ")
+	msg.CompressionRatio = float64(len(msg.Decoded)) / float64(len(msg.Body))
+	return nil
+}
+
+func decoder(coding string, r io.Reader) (io.Reader, error) {
+	switch coding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		// deflate should be zlib wrapped, but some servers send raw streams
+		br := bufio.NewReader(r)
+		if head, err := br.Peek(2); err == nil && (uint16(head[0])<<8|uint16(head[1]))%31 == 0 && head[0]&0x0f == 8 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	case "br":
+		return brotli.NewReader(r), nil
+	}
+	return nil, fmt.Errorf("unsupported content encoding %q", coding)
+}
@@ -0,0 +1,83 @@
+package manager
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func compress(coding string, data []byte) []byte {
+	buf := &bytes.Buffer{}
+	var w io.WriteCloser
+	switch coding {
+	case "gzip":
+		w = gzip.NewWriter(buf)
+	case "zlib":
+		w = zlib.NewWriter(buf)
+	case "flate":
+		w, _ = flate.NewWriter(buf, flate.BestSpeed)
+	case "br":
+		w = brotli.NewWriter(buf)
+	}
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestDecodeContent(t *testing.T) {
+	text := []byte("hello hello hello, compressed world")
+	// large enough to be split in many deflate blocks
+	large := &bytes.Buffer{}
+	for i := 0; large.Len() < 256<<10; i++ {
+		fmt.Fprintf(large, "line %v of a long body\n", i)
+	}
+	gzipped := compress("gzip", large.Bytes())
+	tests := []struct {
+		name      string
+		encoding  string
+		body      []byte
+		truncated bool
+		limit     int64
+		want      []byte
+		prefix    bool
+		err       bool
+	}{
+		{name: "gzip", encoding: "gzip", body: compress("gzip", text), want: text},
+		{name: "zlib deflate", encoding: "deflate", body: compress("zlib", text), want: text},
+		{name: "raw deflate", encoding: "deflate", body: compress("flate", text), want: text},
+		{name: "brotli", encoding: "br", body: compress("br", text), want: text},
+		{name: "many codings", encoding: "gzip, br", body: compress("br", compress("gzip", text)), want: text},
+		{name: "identity", encoding: "identity", body: text},
+		{name: "unsupported", encoding: "compress", body: text, err: true},
+		{name: "capped", encoding: "gzip", body: compress("gzip", text), limit: 5, want: text[:5]},
+		{name: "truncated", encoding: "gzip", body: gzipped[:len(gzipped)/2], truncated: true, want: large.Bytes(), prefix: true},
+		{name: "corrupt", encoding: "gzip", body: gzipped[:len(gzipped)/2], err: true},
+	}
+	for _, tt := range tests {
+		msg := &Message{Headers: http.Header{"Content-Encoding": {tt.encoding}}, Body: tt.body, Truncated: tt.truncated}
+		limit := tt.limit
+		if limit == 0 {
+			limit = 1 << 20
+		}
+		err := decodeContent(msg, limit)
+		if (err != nil) != tt.err {
+			t.Errorf("%v: got error %v, want error %v", tt.name, err, tt.err)
+			continue
+		}
+		switch {
+		case tt.prefix:
+			if len(msg.Decoded) == 0 || !bytes.HasPrefix(tt.want, msg.Decoded) {
+				t.Errorf("%v: decoded %v bytes that are not a prefix of the body", tt.name, len(msg.Decoded))
+			}
+		case !bytes.Equal(msg.Decoded, tt.want):
+			t.Errorf("%v: decoded %q, want %q", tt.name, msg.Decoded, tt.want)
+		}
+	}
+}
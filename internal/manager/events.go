@@ -1,21 +1,87 @@
 package manager
 
-import "net/http"
+import (
+	"net/http"
+	"time"
+)
 
 type (
 	// IOEvent represents either an incoming http request or
 	// an outgoing http response
 	IOEvent struct {
-		ID      int64 `json:"id,omitempty"`
-		Request struct {
-			Body    string      `json:"body"`
-			Headers http.Header `json:"headers"`
-		} `json:"request,omitempty"`
-		Response struct {
-			Body    string      `json:"body"`
-			Headers http.Header `json:"headers"`
-		} `json:"Response,omitempty"`
-		Code int    `json:"code,omitempty"`
-		URL  string `json:"url,omitempty"`
+		ID int64 `json:"id,omitempty"`
+		// Seq is the position of the event in the publish order, IDs are
+		// given when requests start but events are published when they end
+		Seq   int64     `json:"seq,omitempty"`
+		Start time.Time `json:"start"`
+		// End is set once the response was sent to the client
+		End    time.Time `json:"end,omitzero"`
+		Method string    `json:"method,omitempty"`
+		Host   string    `json:"host,omitempty"`
+		// RemoteAddr is the address of the client that sent the request
+		RemoteAddr string  `json:"remoteAddr,omitempty"`
+		Request    Message `json:"request,omitempty"`
+		Response   Message `json:"Response,omitempty"`
+		Code       int     `json:"code,omitempty"`
+		URL        string  `json:"url,omitempty"`
+		// Upstream is the target chosen by the router
+		Upstream string `json:"upstream,omitempty"`
+		// Proto is the protocol used by the client and UpstreamProto
+		// the one negotiated with the upstream (eg.: HTTP/1.1, HTTP/2.0)
+		Proto         string `json:"proto,omitempty"`
+		UpstreamProto string `json:"upstreamProto,omitempty"`
+		// TLS is set when the client reached the proxy over TLS
+		TLS bool `json:"tls,omitempty"`
+		// ReplayOf links a replayed request to the event it came from
+		ReplayOf int64 `json:"replayOf,omitempty"`
+		// Tags tell how the request reached the proxy when it
+		// was not sent by a regular client (eg.: replay, composed)
+		Tags []string `json:"tags,omitempty"`
+		// DroppedHeaders lists the headers of a replayed or composed
+		// request left out because their values were redacted
+		DroppedHeaders []string `json:"droppedHeaders,omitempty"`
+		// Faults lists the failures injected on purpose
+		Faults []Fault `json:"faults,omitempty"`
+		// Tunnel is only present for CONNECT requests passed through
+		Tunnel *Tunnel `json:"tunnel,omitempty"`
+		// Parent and Frame are set on WebSocket frames, Parent
+		// being the ID of the upgrade request
+		Parent int64  `json:"parent,omitempty"`
+		Frame  *Frame `json:"frame,omitempty"`
+		// Timings breaks down the upstream call
+		Timings *Timings `json:"timings,omitempty"`
+		// GRPC holds the decoded messages of gRPC calls
+		GRPC *GRPC `json:"grpc,omitempty"`
+	}
+
+	// Message holds the captured headers and body of either
+	// side of an exchange
+	Message struct {
+		// Body is written as text or base64, see Encoding*
+		Body    []byte      `json:"body"`
+		Headers http.Header `json:"headers"`
+		// Truncated is set when the body was larger than the
+		// capture limit and only its prefix was kept
+		Truncated bool `json:"truncated,omitempty"`
+		// Size is the length of the whole body, even when it was truncated
+		Size int64 `json:"size"`
+		// Trailers are only present on responses that sent them
+		Trailers http.Header `json:"trailers,omitempty"`
+		// Decoded is Body without its Content-Encoding (eg.: gzip),
+		// Body keeps the bytes seen on the wire
+		Decoded []byte `json:"decoded,omitempty"`
+		// ContentEncoding lists the encodings removed to produce Decoded
+		ContentEncoding string `json:"contentEncoding,omitempty"`
+		// CompressionRatio is the length of Decoded over the length of Body
+		CompressionRatio float64 `json:"compressionRatio,omitempty"`
 	}
 )
+
+// Elapsed is the time between the request and the end of the response,
+// zero when the exchange did not finish
+func (ev *IOEvent) Elapsed() Duration {
+	if ev.End.IsZero() || ev.End.Before(ev.Start) {
+		return 0
+	}
+	return Duration(ev.End.Sub(ev.Start))
+}
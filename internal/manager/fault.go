@@ -0,0 +1,197 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	// FaultLatency delays the request before it is forwarded
+	FaultLatency = "latency"
+	// FaultStatus answers with an error code instead of calling the upstream
+	FaultStatus = "status"
+	// FaultTruncate closes the connection after part of the response body
+	FaultTruncate = "truncate"
+	// FaultReset resets the client connection without answering
+	FaultReset = "reset"
+)
+
+var errTruncated = errors.New("response truncated by fault injection")
+
+type (
+	// FaultRule injects failures, with the given probability, on requests
+	// under Route, matched by whole path segments like the routes of the
+	// proxy (empty matches everything). When the rule triggers, every
+	// configured fault is applied
+	FaultRule struct {
+		Route       string   `json:"route,omitempty"`
+		Probability float64  `json:"probability"`
+		Latency     Duration `json:"latency,omitempty"`
+		// Status should be a 5xx code
+		Status int `json:"status,omitempty"`
+		// Truncate is the number of body bytes sent before closing the connection
+		Truncate int64 `json:"truncate,omitempty"`
+		Reset    bool  `json:"reset,omitempty"`
+	}
+
+	// Fault describes a failure injected on purpose
+	Fault struct {
+		Kind   string `json:"kind"`
+		Detail string `json:"detail,omitempty"`
+	}
+
+	// truncateWriter fails writes once limit bytes of the body are written
+	truncateWriter struct {
+		http.ResponseWriter
+		limit   int64
+		tripped bool
+	}
+)
+
+// LoadFaults reads a JSON file containing a list of fault rules
+func LoadFaults(file string) ([]FaultRule, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var rules []FaultRule
+	if err := json.Unmarshal(buf, &rules); err != nil {
+		return nil, fmt.Errorf("unable to parse faults from %v: %w", file, err)
+	}
+	return rules, validateFaults(rules)
+}
+
+func validateFaults(rules []FaultRule) error {
+	for _, r := range rules {
+		if r.Probability < 0 || r.Probability > 1 {
+			return fmt.Errorf("fault rule for %q: probability must be between 0 and 1", r.Route)
+		}
+		if r.Status != 0 && (r.Status < 100 || r.Status > 599) {
+			return fmt.Errorf("fault rule for %q: invalid status %v", r.Route, r.Status)
+		}
+	}
+	return nil
+}
+
+// SetFaults replaces all fault rules
+func (m *M) SetFaults(rules []FaultRule) error {
+	if err := validateFaults(rules); err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.faults = append([]FaultRule(nil), rules...)
+	return nil
+}
+
+// Faults returns the active fault rules
+func (m *M) Faults() []FaultRule {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return append([]FaultRule{}, m.faults...)
+}
+
+func (m *M) roll() float64 {
+	if m.Rand != nil {
+		return m.Rand()
+	}
+	return rand.Float64()
+}
+
+// triggeredFaults rolls the dice for every rule matching req
+func (m *M) triggeredFaults(req *http.Request) []FaultRule {
+	var out []FaultRule
+	for _, r := range m.Faults() {
+		if !matchPrefix(req.URL.Path, r.Route) {
+			continue
+		}
+		if m.roll() < r.Probability {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// injectFaults applies the faults triggered for req, it returns the writer
+// the upstream should use, or nil when the request was already handled
+func (m *M) injectFaults(w http.ResponseWriter, req *http.Request, ev *IOEvent) http.ResponseWriter {
+	rules := m.triggeredFaults(req)
+	if len(rules) == 0 {
+		return w
+	}
+	var latency time.Duration
+	for _, r := range rules {
+		latency += time.Duration(r.Latency)
+	}
+	if latency > 0 {
+		ev.Faults = append(ev.Faults, Fault{Kind: FaultLatency, Detail: latency.String()})
+		select {
+		case <-time.After(latency):
+		case <-req.Context().Done():
+			return nil
+		}
+	}
+	for _, r := range rules {
+		switch {
+		case r.Reset:
+			ev.Faults = append(ev.Faults, Fault{Kind: FaultReset})
+			resetConnection(w)
+			return nil
+		case r.Status != 0:
+			ev.Faults = append(ev.Faults, Fault{Kind: FaultStatus, Detail: fmt.Sprint(r.Status)})
+			http.Error(w, "fault injected by inspector", r.Status)
+			return nil
+		case r.Truncate > 0:
+			ev.Faults = append(ev.Faults, Fault{Kind: FaultTruncate, Detail: fmt.Sprintf("after %v bytes", r.Truncate)})
+			return &truncateWriter{ResponseWriter: w, limit: r.Truncate}
+		}
+	}
+	return w
+}
+
+// resetConnection closes the client connection making sure
+// the client gets a RST instead of a regular FIN
+func resetConnection(w http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		// not much we can do, but the client still should get an error
+		panic(http.ErrAbortHandler)
+	}
+	if nc, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = nc.NetConn()
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+func (t *truncateWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) <= t.limit {
+		t.limit -= int64(len(p))
+		return t.ResponseWriter.Write(p)
+	}
+	n, _ := t.ResponseWriter.Write(p[:t.limit])
+	t.limit = 0
+	t.tripped = true
+	http.NewResponseController(t.ResponseWriter).Flush()
+	return n, errTruncated
+}
+
+// abortIfTruncated makes sure the client sees a broken connection instead
+// of a short (but otherwise valid) response
+func abortIfTruncated(w http.ResponseWriter) {
+	if t, ok := w.(*truncateWriter); ok && t.tripped {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (t *truncateWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
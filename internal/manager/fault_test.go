@@ -0,0 +1,34 @@
+package manager
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTriggeredFaults(t *testing.T) {
+	m := &M{Rand: func() float64 { return 0.5 }}
+	err := m.SetFaults([]FaultRule{
+		{Route: "/api", Probability: 1, Status: 503},
+		{Route: "/slow/", Probability: 1, Latency: 1},
+		{Route: "", Probability: 0.1, Status: 500},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: "/api", want: 1},
+		{path: "/api/users", want: 1},
+		{path: "/apiary", want: 0},
+		{path: "/slow/x", want: 1},
+		{path: "/slow", want: 0},
+		{path: "/", want: 0},
+	}
+	for _, tt := range tests {
+		if got := m.triggeredFaults(httptest.NewRequest("GET", tt.path, nil)); len(got) != tt.want {
+			t.Errorf("%v: got %v faults, want %v", tt.path, len(got), tt.want)
+		}
+	}
+}
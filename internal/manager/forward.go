@@ -0,0 +1,231 @@
+package manager
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"time"
+)
+
+// TagDecrypted marks events captured inside an intercepted TLS tunnel
+const TagDecrypted = "decrypted"
+
+type ctxKey int
+
+const (
+	decryptedKey ctxKey = iota
+	eventKey
+)
+
+type (
+	// Tunnel describes a CONNECT tunnel passed through without inspection,
+	// BytesSent goes from the client to the server and BytesReceived the
+	// other way around
+	Tunnel struct {
+		Host          string   `json:"host"`
+		Duration      Duration `json:"duration"`
+		BytesSent     int64    `json:"bytesSent"`
+		BytesReceived int64    `json:"bytesReceived"`
+	}
+
+	// Interceptor provides the TLS configuration used to impersonate
+	// host when decrypting a CONNECT tunnel
+	Interceptor interface {
+		ServerConfig(host string) (*tls.Config, error)
+	}
+
+	// bufferedConn reads from r, which holds bytes already read from Conn
+	bufferedConn struct {
+		net.Conn
+		r *bufio.Reader
+	}
+
+	// connListener hands out a single connection and blocks
+	// further calls to Accept until it is closed
+	connListener struct {
+		conn   net.Conn
+		once   sync.Once
+		closed chan struct{}
+	}
+)
+
+// forwardProxy sends each request to the host in its own (absolute) URL,
+// as expected from an HTTP proxy
+func (m *M) forwardProxy() *httputil.ReverseProxy {
+	m.forwardOnce.Do(func() {
+		m.fwd = &httputil.ReverseProxy{
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.SetXForwarded()
+			},
+			ModifyResponse: recordUpstream,
+			Transport:      m.Transport,
+		}
+	})
+	return m.fwd
+}
+
+// serveForward handles the requests that only make sense when working as
+// a forward proxy, returns false if req should follow the regular path
+func (m *M) serveForward(w http.ResponseWriter, req *http.Request) bool {
+	if req.Method == http.MethodConnect {
+		if m.Intercept != nil {
+			m.intercept(w, req)
+		} else {
+			m.tunnel(w, req)
+		}
+		return true
+	}
+	if !req.URL.IsAbs() {
+		http.Error(w, "inspector is running as a forward proxy, requests must use an absolute URL", http.StatusBadRequest)
+		return true
+	}
+	return false
+}
+
+// tunnel connects the client to the requested host and copies bytes in both
+// directions until one of them closes the connection
+func (m *M) tunnel(w http.ResponseWriter, req *http.Request) {
+	ev := &IOEvent{
+		ID:         m.nextID(),
+		Start:      time.Now(),
+		Method:     req.Method,
+		Host:       req.Host,
+		RemoteAddr: req.RemoteAddr,
+		Proto:      req.Proto,
+		URL:        req.Host,
+	}
+	ev.Request.Headers = req.Header.Clone()
+	ev.Tunnel = &Tunnel{Host: req.Host}
+	defer func() {
+		ev.End = time.Now()
+		ev.Tunnel.Duration = Duration(ev.End.Sub(ev.Start))
+		m.publish(ev)
+	}()
+
+	upstream, err := (&net.Dialer{Timeout: 10 * time.Second}).DialContext(req.Context(), "tcp", req.Host)
+	if err != nil {
+		ev.Code = http.StatusBadGateway
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		ev.Code = http.StatusInternalServerError
+		http.Error(w, "unable to hijack connection", http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	ev.Code = http.StatusOK
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// brw reads from conn, but might already hold bytes sent by the client
+		ev.Tunnel.BytesSent, _ = io.Copy(upstream, brw.Reader)
+		closeWrite(upstream)
+	}()
+	ev.Tunnel.BytesReceived, err = io.Copy(conn, upstream)
+	if err != nil {
+		log.Printf("Tunnel to %v: %v", req.Host, err)
+	}
+	closeWrite(conn)
+	wg.Wait()
+}
+
+// closeWrite signals the end of the stream while still reading from conn
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+// intercept answers a CONNECT as if the tunnel was established, then
+// terminates TLS with a certificate for the requested host and serves the
+// decrypted requests through the regular capture path
+func (m *M) intercept(w http.ResponseWriter, req *http.Request) {
+	host := req.Host
+	if h, port, err := net.SplitHostPort(host); err == nil && port == "443" {
+		host = h
+	}
+	cfg, err := m.Intercept.ServerConfig(host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "unable to hijack connection", http.StatusInternalServerError)
+		return
+	}
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		conn.Close()
+		return
+	}
+	tlsConn := tls.Server(&bufferedConn{Conn: conn, r: brw.Reader}, cfg)
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	err = tlsConn.HandshakeContext(ctx)
+	cancel()
+	if err != nil {
+		log.Printf("TLS handshake for %v: %v", host, err)
+		conn.Close()
+		return
+	}
+
+	ln := &connListener{conn: tlsConn, closed: make(chan struct{})}
+	proxy := m.Proxy()
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.URL.Scheme = "https"
+			r.URL.Host = host
+			proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), decryptedKey, true)))
+		}),
+		ConnState: func(_ net.Conn, state http.ConnState) {
+			if state == http.StateClosed || state == http.StateHijacked {
+				ln.Close()
+			}
+		},
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	srv.Serve(ln)
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	var conn net.Conn
+	l.once.Do(func() { conn = l.conn })
+	if conn != nil {
+		return conn, nil
+	}
+	<-l.closed
+	return nil, net.ErrClosed
+}
+
+func (l *connListener) Close() error {
+	select {
+	case <-l.closed:
+	default:
+		close(l.closed)
+	}
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
@@ -0,0 +1,129 @@
+package manager
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type (
+	// GRPCDecoder converts gRPC messages to JSON, method is the
+	// request path (eg.: /pkg.Service/Method)
+	GRPCDecoder interface {
+		DecodeRequest(method string, msg []byte) (json.RawMessage, error)
+		DecodeResponse(method string, msg []byte) (json.RawMessage, error)
+	}
+
+	// GRPC holds the decoded messages of a gRPC call, Status and
+	// Message come from the grpc-status and grpc-message trailers
+	GRPC struct {
+		Method    string            `json:"method"`
+		Requests  []json.RawMessage `json:"requests,omitempty"`
+		Responses []json.RawMessage `json:"responses,omitempty"`
+		Status    string            `json:"status,omitempty"`
+		Message   string            `json:"message,omitempty"`
+		// Errors lists the messages that could not be decoded
+		Errors []string `json:"errors,omitempty"`
+	}
+)
+
+var grpcCodes = []string{
+	"OK", "Canceled", "Unknown", "InvalidArgument", "DeadlineExceeded", "NotFound",
+	"AlreadyExists", "PermissionDenied", "ResourceExhausted", "FailedPrecondition",
+	"Aborted", "OutOfRange", "Unimplemented", "Internal", "Unavailable", "DataLoss",
+	"Unauthenticated",
+}
+
+// StatusName returns the name of the status code (eg.: NotFound)
+func (g *GRPC) StatusName() string {
+	code, err := strconv.Atoi(g.Status)
+	if err != nil || code < 0 || code >= len(grpcCodes) {
+		return g.Status
+	}
+	return grpcCodes[code]
+}
+
+func isGRPC(h http.Header) bool {
+	ct := h.Get("Content-Type")
+	return ct == "application/grpc" || strings.HasPrefix(ct, "application/grpc+")
+}
+
+// decodeGRPC fills ev.GRPC when ev is a gRPC call and a decoder is available
+func (m *M) decodeGRPC(ev *IOEvent, reqBody, resBody []byte) {
+	if m.GRPC == nil || !isGRPC(ev.Request.Headers) {
+		return
+	}
+	g := &GRPC{Method: ev.URL}
+	if u, err := url.Parse(ev.URL); err == nil {
+		g.Method = u.Path
+	}
+	decode := func(body []byte, truncated bool, encoding string, fn func(string, []byte) (json.RawMessage, error)) []json.RawMessage {
+		msgs, err := grpcMessages(body, encoding)
+		if err != nil && !truncated {
+			g.Errors = append(g.Errors, err.Error())
+		}
+		var out []json.RawMessage
+		for _, msg := range msgs {
+			js, err := fn(g.Method, msg)
+			if err != nil {
+				g.Errors = append(g.Errors, err.Error())
+				continue
+			}
+			out = append(out, js)
+		}
+		if truncated {
+			g.Errors = append(g.Errors, "body truncated, only the captured messages were decoded")
+		}
+		return out
+	}
+	g.Requests = decode(reqBody, ev.Request.Truncated, ev.Request.Headers.Get("Grpc-Encoding"), m.GRPC.DecodeRequest)
+	g.Responses = decode(resBody, ev.Response.Truncated, ev.Response.Headers.Get("Grpc-Encoding"), m.GRPC.DecodeResponse)
+
+	// trailers-only responses (usually errors) send the status as headers
+	for _, h := range []http.Header{ev.Response.Trailers, ev.Response.Headers} {
+		if status := h.Get("Grpc-Status"); status != "" && g.Status == "" {
+			g.Status = status
+			g.Message, _ = url.PathUnescape(h.Get("Grpc-Message"))
+		}
+	}
+	ev.GRPC = g
+}
+
+// grpcMessages splits a body into length-prefixed gRPC messages
+func grpcMessages(body []byte, encoding string) ([][]byte, error) {
+	var out [][]byte
+	for len(body) > 0 {
+		if len(body) < 5 {
+			return out, fmt.Errorf("incomplete gRPC message header")
+		}
+		compressed, size := body[0] == 1, binary.BigEndian.Uint32(body[1:5])
+		body = body[5:]
+		if uint32(len(body)) < size {
+			return out, fmt.Errorf("incomplete gRPC message, expecting %v bytes got %v", size, len(body))
+		}
+		msg := body[:size]
+		body = body[size:]
+		if compressed {
+			if encoding != "gzip" {
+				return out, fmt.Errorf("unsupported gRPC encoding %q", encoding)
+			}
+			zr, err := gzip.NewReader(bytes.NewReader(msg))
+			if err != nil {
+				return out, err
+			}
+			msg, err = io.ReadAll(zr)
+			if err != nil {
+				return out, err
+			}
+		}
+		out = append(out, msg)
+	}
+	return out, nil
+}
@@ -0,0 +1,78 @@
+package manager
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"testing"
+)
+
+func grpcFrame(compressed bool, msg []byte) []byte {
+	flag := byte(0)
+	if compressed {
+		flag = 1
+	}
+	out := binary.BigEndian.AppendUint32([]byte{flag}, uint32(len(msg)))
+	return append(out, msg...)
+}
+
+func gzipped(msg []byte) []byte {
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	zw.Write(msg)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestGRPCMessages(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     []byte
+		encoding string
+		want     []string
+		err      bool
+	}{
+		{name: "empty", body: nil},
+		{name: "single", body: grpcFrame(false, []byte("one")), want: []string{"one"}},
+		{name: "empty message", body: grpcFrame(false, nil), want: []string{""}},
+		{
+			name: "many",
+			body: append(grpcFrame(false, []byte("one")), grpcFrame(false, []byte("two"))...),
+			want: []string{"one", "two"},
+		},
+		{
+			name:     "gzip",
+			body:     append(grpcFrame(true, gzipped([]byte("one"))), grpcFrame(false, []byte("two"))...),
+			encoding: "gzip",
+			want:     []string{"one", "two"},
+		},
+		{name: "unsupported encoding", body: grpcFrame(true, []byte("one")), encoding: "snappy", err: true},
+		{
+			name: "truncated message",
+			body: append(grpcFrame(false, []byte("one")), grpcFrame(false, []byte("two"))[:6]...),
+			want: []string{"one"},
+			err:  true,
+		},
+		{
+			name: "truncated header",
+			body: append(grpcFrame(false, []byte("one")), 0, 0),
+			want: []string{"one"},
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		got, err := grpcMessages(tt.body, tt.encoding)
+		if (err != nil) != tt.err {
+			t.Errorf("%v: got error %v, want error %v", tt.name, err, tt.err)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%v: got %q, want %q", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if string(got[i]) != tt.want[i] {
+				t.Errorf("%v: message %v is %q, want %q", tt.name, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package manager
+
+import (
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andrebq/inspector/internal/har"
+)
+
+// HAR converts events to an HTTP Archive 1.2 document
+func HAR(events []*IOEvent) *har.File {
+	out := &har.File{
+		Log: har.Log{
+			Version: "1.2",
+			Creator: har.Creator{Name: "inspector", Version: version()},
+			Entries: []har.Entry{},
+		},
+	}
+	// frames are attached to the entry of their upgrade request
+	entries := map[int64]int{}
+	for _, ev := range events {
+		if ev.Frame != nil {
+			continue
+		}
+		entries[ev.ID] = len(out.Log.Entries)
+		out.Log.Entries = append(out.Log.Entries, harEntry(ev))
+	}
+	for _, ev := range events {
+		idx, ok := entries[ev.Parent]
+		if ev.Frame == nil || !ok {
+			continue
+		}
+		typ := "receive"
+		if ev.Frame.Direction == FromClient {
+			typ = "send"
+		}
+		payload, _ := encodeBody(ev.Frame.IsText(), ev.Frame.Payload)
+		e := &out.Log.Entries[idx]
+		e.WebSocketMessages = append(e.WebSocketMessages, har.WebSocketMessage{
+			Type:   typ,
+			Time:   float64(ev.Start.UnixNano()) / 1e9,
+			Opcode: ev.Frame.Opcode,
+			Data:   payload,
+		})
+	}
+	return out
+}
+
+func harEntry(ev *IOEvent) har.Entry {
+	// without an upstream call (eg.: mocks, faults, tunnels) the
+	// whole exchange is reported as waiting
+	e := har.Entry{
+		StartedDateTime: ev.Start.Format(time.RFC3339Nano),
+		Timings:         har.Timings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1, Wait: millis(ev.Elapsed())},
+	}
+	if t := ev.Timings; t != nil {
+		// HAR includes the TLS handshake in connect
+		e.Timings = har.Timings{
+			Blocked: -1,
+			DNS:     millis(t.DNS),
+			Connect: millis(t.Connect + t.TLS),
+			SSL:     millis(t.TLS),
+			Wait:    millis(t.Wait()),
+			Receive: millis(t.Receive()),
+		}
+	}
+	// HAR requires time to be the sum of the timings, ssl
+	// excluded since it is already part of connect
+	for _, v := range []float64{e.Timings.Blocked, e.Timings.DNS, e.Timings.Connect, e.Timings.Send, e.Timings.Wait, e.Timings.Receive} {
+		if v > 0 {
+			e.Time += v
+		}
+	}
+	fullURL, u := harURL(ev)
+	e.Request = har.Request{
+		Method:      ev.Method,
+		URL:         fullURL,
+		HTTPVersion: ev.Proto,
+		Cookies:     harCookies((&http.Request{Header: ev.Request.Headers}).Cookies()),
+		Headers:     harHeaders(ev.Request.Headers),
+		QueryString: []har.NameValue{},
+		HeadersSize: -1,
+		BodySize:    bodySize(ev.Request),
+	}
+	if u != nil {
+		e.Request.QueryString = harValues(u.Query())
+	}
+	if len(ev.Request.Body) > 0 {
+		text, enc := encodeBody(ev.Request.IsText(), ev.Request.Body)
+		e.Request.PostData = &har.PostData{
+			MimeType: ev.Request.ContentType(),
+			Text:     text,
+		}
+		if enc == EncodingBase64 {
+			e.Request.PostData.Comment = "text is base64 encoded"
+		}
+	}
+	if ev.Request.Truncated {
+		e.Request.Comment = "body truncated by inspector"
+	}
+
+	// HAR content holds the decoded body, compression tells the bytes saved
+	respText, respEnc := encodeBody(ev.Response.ContentIsText(), ev.Response.Content())
+	if respEnc == EncodingText {
+		respEnc = ""
+	}
+	e.Response = har.Response{
+		Status:      ev.Code,
+		StatusText:  http.StatusText(ev.Code),
+		HTTPVersion: responseProto(ev),
+		Cookies:     harCookies((&http.Response{Header: ev.Response.Headers}).Cookies()),
+		Headers:     harHeaders(ev.Response.Headers),
+		RedirectURL: ev.Response.Headers.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    bodySize(ev.Response),
+		Content: har.Content{
+			Size:     bodySize(ev.Response),
+			MimeType: ev.Response.Headers.Get("Content-Type"),
+			Text:     respText,
+			Encoding: respEnc,
+		},
+	}
+	if ev.Response.Decoded != nil {
+		e.Response.Content.Size = int64(len(ev.Response.Decoded))
+		e.Response.Content.Compression = e.Response.Content.Size - bodySize(ev.Response)
+	}
+	if e.Response.Content.MimeType == "" {
+		e.Response.Content.MimeType = "application/octet-stream"
+	}
+	if ev.Response.Truncated {
+		e.Response.Comment = "body truncated by inspector"
+	}
+	return e
+}
+
+func harHeaders(h http.Header) []har.NameValue {
+	out := []har.NameValue{}
+	for _, k := range sortedKeys(h) {
+		for _, v := range h[k] {
+			out = append(out, har.NameValue{Name: k, Value: v})
+		}
+	}
+	return out
+}
+
+func harValues(v url.Values) []har.NameValue {
+	return harHeaders(http.Header(v))
+}
+
+func harCookies(cookies []*http.Cookie) []har.Cookie {
+	out := []har.Cookie{}
+	for _, c := range cookies {
+		hc := har.Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			HTTPOnly: c.HttpOnly,
+			Secure:   c.Secure,
+		}
+		if !c.Expires.IsZero() {
+			hc.Expires = c.Expires.Format(time.RFC3339)
+		}
+		out = append(out, hc)
+	}
+	return out
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// responseProto is the protocol of the upstream response, or the one used
+// with the client when the proxy answered by itself (eg.: mocks, faults)
+func responseProto(ev *IOEvent) string {
+	if ev.UpstreamProto != "" {
+		return ev.UpstreamProto
+	}
+	return ev.Proto
+}
+
+func version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	return info.Main.Version
+}
+
+func millis(d Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// bodySize falls back to the captured body for events
+// recorded before sizes were tracked
+func bodySize(m Message) int64 {
+	if m.Size == 0 {
+		return int64(len(m.Body))
+	}
+	return m.Size
+}
+
+// harURL returns the absolute URL of the request, along with its parsed
+// form when it has a query string to report
+func harURL(ev *IOEvent) (string, *url.URL) {
+	if ev.Tunnel != nil {
+		// CONNECT targets are host:port, which url.Parse reads as a scheme
+		return "https://" + strings.TrimSuffix(ev.URL, ":443"), nil
+	}
+	u, err := url.Parse(ev.URL)
+	if err != nil {
+		return ev.URL, nil
+	}
+	if u.Host == "" {
+		u.Scheme, u.Host = "http", ev.Host
+		if ev.TLS {
+			u.Scheme = "https"
+		}
+	}
+	return u.String(), u
+}
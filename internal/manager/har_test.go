@@ -0,0 +1,22 @@
+package manager
+
+import "testing"
+
+func TestHARURL(t *testing.T) {
+	tests := []struct {
+		name string
+		ev   IOEvent
+		want string
+	}{
+		{name: "relative", ev: IOEvent{URL: "/a?b=c", Host: "example.com"}, want: "http://example.com/a?b=c"},
+		{name: "relative over tls", ev: IOEvent{URL: "/a", Host: "example.com:8443", TLS: true}, want: "https://example.com:8443/a"},
+		{name: "absolute", ev: IOEvent{URL: "https://example.com/a", Host: "example.com"}, want: "https://example.com/a"},
+		{name: "tunnel", ev: IOEvent{URL: "example.com:443", Tunnel: &Tunnel{}}, want: "https://example.com"},
+		{name: "tunnel other port", ev: IOEvent{URL: "example.com:8443", Tunnel: &Tunnel{}}, want: "https://example.com:8443"},
+	}
+	for _, tt := range tests {
+		if got, _ := harURL(&tt.ev); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
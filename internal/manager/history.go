@@ -0,0 +1,39 @@
+package manager
+
+type (
+	// history is a fixed size ring buffer holding the most recent events
+	history struct {
+		events []*IOEvent
+		next   int
+		full   bool
+	}
+)
+
+func newHistory(size int) *history {
+	return &history{events: make([]*IOEvent, size)}
+}
+
+func (h *history) add(ev *IOEvent) {
+	h.events[h.next] = ev
+	h.next = (h.next + 1) % len(h.events)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// since returns, from oldest to newest, all events published after seq
+func (h *history) since(seq int64) []*IOEvent {
+	var ordered []*IOEvent
+	if h.full {
+		ordered = append(ordered, h.events[h.next:]...)
+	}
+	ordered = append(ordered, h.events[:h.next]...)
+
+	var out []*IOEvent
+	for _, ev := range ordered {
+		if ev.Seq > seq {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
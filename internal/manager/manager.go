@@ -5,94 +5,244 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
-	"net/http/httptest"
 	"net/http/httputil"
+	"sort"
+	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
-type (
-	requestData struct {
-		ID       int64
-		Req      *http.Request
-		Response *httptest.ResponseRecorder
-		Body     []byte
-	}
+// DefaultBodyLimit is the amount of bytes kept from each body
+// when M.BodyLimit is not set
+const DefaultBodyLimit = 1 << 20
+
+// backlogLimit caps how many missed events are sent to a probe when it connects
+const backlogLimit = 1000
 
+type (
 	// M controls both the proxy redirection and the clients that want to inspect requests
 	M struct {
-		lock     sync.RWMutex
-		probes   map[chan *IOEvent]struct{}
+		lock   sync.RWMutex
+		probes map[chan *IOEvent]struct{}
+		// Upstream receives every request not handled by Routes
 		Upstream *httputil.ReverseProxy
+		Routes   *Router
+		// Forward makes M work as a forward proxy, sending requests to
+		// the host in their URL and passing CONNECT tunnels through
+		Forward bool
+		// Transport is used by the forward proxy, defaults to http.DefaultTransport
+		Transport http.RoundTripper
+		// Intercept, when set in forward mode, decrypts CONNECT tunnels
+		// so the requests inside them are captured as well
+		Intercept   Interceptor
+		fwd         *httputil.ReverseProxy
+		forwardOnce sync.Once
+
+		// BodyLimit is the maximum number of bytes captured from each
+		// request or response body, anything after that is still
+		// proxied but not kept in the event
+		BodyLimit int64
+
+		// History is the number of recent events kept in memory so probes
+		// connecting later can catch up, zero disables it
+		History int
+		history *history
+
+		// Store, when set, receives every event and is used to answer
+		// queries about events no longer in the history
+		Store Store
+
+		breakpoints []Breakpoint
+		bpcount     int64
+		held        map[int64]*Held
+
+		mocks     []MockRule
+		mockcount int64
+
+		faults []FaultRule
+		// Rand is used to decide if a fault should be injected,
+		// defaults to math/rand
+		Rand func() float64
 
-		rcount int64
+		// GRPC, when set, decodes the messages of gRPC calls
+		GRPC GRPCDecoder
+
+		// Redact, when set, hides sensitive data from every event
+		// before it is published
+		Redact  *Redactor
+		secrets secrets
+
+		rcount   int64
+		seedOnce sync.Once
+		// publishing keeps sequence numbers, store appends and
+		// broadcasts in the same order, seq is guarded by it
+		publishing sync.Mutex
+		seq        int64
+
+		metrics metrics
 	}
 )
 
 func (m *M) Proxy() http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		if !m.hasProbes() {
+	return m.measure(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if m.Forward && m.serveForward(w, req) {
+			return
+		}
+		if !m.capturing() {
 			// bypass all the processing since nobody is looking at the data
-			m.Upstream.ServeHTTP(w, req)
+			m.forward(w, req, nil)
 			return
 		}
 		w.Header().Set("X-Inspected", "true")
-		ev := m.inspectRequest(req)
-		log := httptest.NewRecorder()
-		m.Upstream.ServeHTTP(log, req)
-		for k, vals := range log.Header() {
-			for _, v := range vals {
-				w.Header().Add(k, v)
-			}
+		ev, reqBody := m.inspectRequest(req)
+		res := &captureWriter{ResponseWriter: w, body: newLimitedBuffer(m.bodyLimit())}
+		// the upstream panics with http.ErrAbortHandler when the client goes
+		// away mid-stream, the partial exchange is still worth reporting
+		defer func() {
+			// trailers are only known after the upstream is done
+			res.trailer = res.trailers()
+			go m.inspectResponse(ev, reqBody, res)
+		}()
+		if isWebSocket(req) {
+			res.wrap = m.captureWebSocket(ev, reqBody, res)
 		}
-		w.WriteHeader(log.Code)
-		w.Write(log.Body.Bytes())
-		go m.inspectResponse(ev, log)
-	})
+		if !m.holdRequest(res, req, ev, reqBody) {
+			return
+		}
+		out := m.injectFaults(res, req, ev)
+		if out == nil {
+			return
+		}
+		if !m.serveMock(out, req, ev) {
+			m.serveHeld(out, req, ev)
+		}
+		abortIfTruncated(out)
+	}))
 }
 
 func (m *M) Manager() http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		// disables browser 'smart content guessing'
-		w.Header().Set("X-Content-Type-Options", "nosniff")
-		flush, ok := w.(http.Flusher)
-		if !ok {
-			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprintf(w, "Response cannot be chunked!")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/request-stream", m.requestStream)
+	mux.HandleFunc("/events", m.events)
+	mux.HandleFunc("/events/", m.event)
+	mux.HandleFunc("/export/har", m.exportHAR)
+	mux.HandleFunc("/compose", m.compose)
+	mux.HandleFunc("/breakpoints", m.breakpointsAPI)
+	mux.HandleFunc("/breakpoints/", m.breakpointsAPI)
+	mux.HandleFunc("/held", m.heldAPI)
+	mux.HandleFunc("/held/", m.heldAPI)
+	mux.HandleFunc("/mocks", m.mocksAPI)
+	mux.HandleFunc("/mocks/", m.mocksAPI)
+	mux.HandleFunc("/faults", m.faultsAPI)
+	mux.HandleFunc("/metrics", m.metricsAPI)
+	// older clients connect directly to the root, anything
+	// else is an unknown path rather than a stream
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			http.NotFound(w, req)
+			return
+		}
+		m.requestStream(w, req)
+	})
+	return mux
+}
+
+// requestStream sends every captured event as a JSON line, when the since
+// query parameter is present, events published after that sequence number
+// (see IOEvent.Seq) still kept in the history are sent before any live event
+func (m *M) requestStream(w http.ResponseWriter, req *http.Request) {
+	// disables browser 'smart content guessing'
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	flush, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Response cannot be chunked!")
+		return
+	}
+	since := int64(-1)
+	if req.URL.Query().Has("since") {
+		var err error
+		since, err = strconv.ParseInt(req.FormValue("since"), 10, 64)
+		if err != nil {
+			http.Error(w, "since must be a sequence number", http.StatusBadRequest)
 			return
 		}
-		probe := m.registerProbe()
-		defer m.removeProbe(probe)
-		for {
-			select {
-			case <-req.Context().Done():
+	}
+	probe, backlog := m.registerProbe(since)
+	defer m.removeProbe(probe)
+	sent := make(map[int64]bool, len(backlog))
+	for _, ev := range backlog {
+		sent[ev.ID] = true
+		if writeEvent(w, ev) != nil {
+			return
+		}
+	}
+	flush.Flush()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case ev, open := <-probe:
+			if !open {
+				return
+			}
+			if sent[ev.ID] {
+				// already part of the backlog
+				delete(sent, ev.ID)
+				continue
+			}
+			if writeEvent(w, ev) != nil {
 				return
-			case ev, open := <-probe:
-				if !open {
-					return
-				}
-				buf, _ := json.Marshal(ev)
-				_, err := w.Write(buf)
-				if err != nil {
-					return
-				}
-				fmt.Fprintln(w)
-				flush.Flush()
 			}
+			flush.Flush()
 		}
-	})
+	}
+}
+
+func writeEvent(w io.Writer, ev *IOEvent) error {
+	buf, _ := json.Marshal(ev)
+	_, err := w.Write(buf)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w)
+	return err
 }
 
-func (m *M) registerProbe() chan *IOEvent {
+// registerProbe adds a new probe and returns the (at most backlogLimit)
+// events from history it missed, a negative since skips the backlog
+// entirely. Events published while the backlog is loaded might be
+// delivered by both
+func (m *M) registerProbe(since int64) (chan *IOEvent, []*IOEvent) {
 	m.lock.Lock()
-	defer m.lock.Unlock()
 	probe := make(chan *IOEvent, 1000)
 	if m.probes == nil {
 		m.probes = make(map[chan *IOEvent]struct{})
 	}
 	m.probes[probe] = struct{}{}
-	return probe
+	var backlog []*IOEvent
+	if since >= 0 && m.Store == nil && m.history != nil {
+		backlog = m.history.since(since)
+	}
+	m.lock.Unlock()
+
+	// the store is read without the lock, otherwise
+	// proxied requests would wait for the disk
+	if since >= 0 && m.Store != nil {
+		var err error
+		backlog, err = m.Store.Query(Query{AfterSeq: since, Limit: backlogLimit})
+		if err != nil {
+			log.Printf("Unable to load backlog from store: %v", err)
+		}
+		sort.Slice(backlog, func(i, j int) bool { return backlog[i].Seq < backlog[j].Seq })
+	}
+	if len(backlog) > backlogLimit {
+		backlog = backlog[len(backlog)-backlogLimit:]
+	}
+	return probe, backlog
 }
 
 func (m *M) removeProbe(p chan *IOEvent) {
@@ -104,47 +254,135 @@ func (m *M) removeProbe(p chan *IOEvent) {
 	delete(m.probes, p)
 }
 
-func (m *M) inspectResponse(ev *IOEvent, res *httptest.ResponseRecorder) {
+func (m *M) inspectResponse(ev *IOEvent, reqBody *limitedBuffer, res *captureWriter) {
 	if ev == nil {
 		return
 	}
-	m.lock.RLock()
-	defer m.lock.RUnlock()
-	if len(m.probes) == 0 {
-		return
+	res.done.Do(func() { m.reportExchange(ev, reqBody, res) })
+}
+
+func (m *M) reportExchange(ev *IOEvent, reqBody *limitedBuffer, res *captureWriter) {
+	ev.End = time.Now()
+	reqData, truncated := reqBody.snapshot()
+	ev.Request.Body = reqData
+	ev.Request.Truncated = truncated
+	ev.Request.Size = reqBody.size()
+
+	ev.Code = res.code
+	resData, truncated := res.body.snapshot()
+	ev.Response.Body = resData
+	ev.Response.Truncated = truncated
+	ev.Response.Size = res.body.size()
+	ev.Response.Headers = res.header
+	ev.Response.Trailers = res.trailer
+	for _, msg := range []*Message{&ev.Request, &ev.Response} {
+		if err := decodeContent(msg, m.bodyLimit()); err != nil {
+			log.Printf("Unable to decode body of event %v: %v", ev.ID, err)
+		}
 	}
-	ev.Code = res.Code
-	// TODO: change this to use bytes instead
-	ev.Response.Body = res.Body.String()
-	ev.Response.Headers = res.Header()
+	m.decodeGRPC(ev, reqData, resData)
+	m.publish(ev)
+}
 
+// publish records ev in the history and sends it to every probe
+func (m *M) publish(ev *IOEvent) {
+	var orig *secret
+	if m.Redact != nil {
+		orig = &secret{header: ev.Request.Headers.Clone(), url: ev.URL, body: ev.Request.Body}
+		m.Redact.Apply(ev)
+		orig.publishedURL, orig.published = ev.URL, ev.Request.Body
+		if !hasRedacted(ev.Request.Headers) && orig.url == ev.URL && bytes.Equal(orig.body, ev.Request.Body) {
+			orig = nil
+		}
+	}
+	// events reach the store and the probes in sequence order, so a probe
+	// resuming from the last sequence it saw never skips an earlier one
+	m.seed()
+	m.publishing.Lock()
+	defer m.publishing.Unlock()
+	m.seq++
+	ev.Seq = m.seq
+	// the store has its own lock, writing to it while holding
+	// m.lock would stall every proxied request
+	if m.Store != nil {
+		if err := m.Store.Append(ev); err != nil {
+			log.Printf("Unable to store event %v: %v", ev.ID, err)
+		}
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if orig != nil {
+		m.secrets.keep(ev.ID, orig)
+	}
+	if m.History > 0 {
+		if m.history == nil {
+			m.history = newHistory(m.History)
+		}
+		m.history.add(ev)
+	}
 	for probe := range m.probes {
 		// avoid blocking if probes are too slow to consume
 		select {
 		case probe <- ev:
 		default:
+			atomic.AddInt64(&m.metrics.dropped, 1)
 		}
 	}
 }
 
-func (m *M) hasProbes() bool {
+// capturing reports if there is anyone interested in the events,
+// either a connected probe, the history or the store. Breakpoints, mocks
+// and faults also need the full processing
+func (m *M) capturing() bool {
 	m.lock.RLock()
-	val := len(m.probes) > 0
+	val := len(m.probes) > 0 || m.History > 0 || m.Store != nil || len(m.breakpoints) > 0 || len(m.mocks) > 0 || len(m.faults) > 0
 	m.lock.RUnlock()
 	return val
 }
 
-func (m *M) inspectRequest(req *http.Request) *IOEvent {
-	rid := atomic.AddInt64(&m.rcount, 1)
-	body, _ := io.ReadAll(req.Body)
-	req.Body = io.NopCloser(bytes.NewBuffer(body))
+func (m *M) bodyLimit() int64 {
+	if m.BodyLimit <= 0 {
+		return DefaultBodyLimit
+	}
+	return m.BodyLimit
+}
+
+// nextID returns the next event ID, continuing from the last
+// stored event when a store is available
+func (m *M) nextID() int64 {
+	m.seed()
+	return atomic.AddInt64(&m.rcount, 1)
+}
+
+// seed continues the ID and publish sequences from the store
+func (m *M) seed() {
+	m.seedOnce.Do(func() {
+		if m.Store != nil {
+			atomic.StoreInt64(&m.rcount, m.Store.LastID())
+			m.seq = m.Store.LastSeq()
+		}
+	})
+}
+
+func (m *M) inspectRequest(req *http.Request) (*IOEvent, *limitedBuffer) {
+	rid := m.nextID()
+	body := newLimitedBuffer(m.bodyLimit())
+	req.Body = &teeBody{ReadCloser: req.Body, capture: body}
 
 	ev := &IOEvent{
-		ID:   rid,
-		Code: 0,
-		URL:  req.URL.String(),
+		ID:         rid,
+		Start:      time.Now(),
+		Method:     req.Method,
+		Host:       req.Host,
+		RemoteAddr: req.RemoteAddr,
+		Proto:      req.Proto,
+		TLS:        req.TLS != nil,
+		Code:       0,
+		URL:        req.URL.String(),
+	}
+	ev.Request.Headers = req.Header.Clone()
+	if req.Context().Value(decryptedKey) != nil {
+		ev.Tags = append(ev.Tags, TagDecrypted)
 	}
-	ev.Request.Body = string(body)
-	ev.Request.Headers = req.Header
-	return ev
+	return ev, body
 }
@@ -0,0 +1,237 @@
+package manager
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds (in seconds) of the latency histogram
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type (
+	// metrics keeps the counters exposed by /metrics
+	metrics struct {
+		lock     sync.Mutex
+		requests map[requestKey]int64
+		latency  map[string]*histogram
+
+		inflight int64
+		bytesIn  int64
+		bytesOut int64
+		dropped  int64
+	}
+
+	requestKey struct {
+		method, route, status string
+	}
+
+	histogram struct {
+		counts []int64
+		sum    float64
+		count  int64
+	}
+
+	// metricsWriter records the status and size of a response
+	metricsWriter struct {
+		http.ResponseWriter
+		code    int
+		bytes   int64
+		connect bool
+		upgrade bool
+		// reset is set when the connection was hijacked for
+		// anything else (eg.: a reset fault)
+		reset bool
+	}
+
+	// countingBody counts the bytes read from a request body
+	countingBody struct {
+		io.ReadCloser
+		n *int64
+	}
+)
+
+// measure wraps the proxy handler so every request is counted,
+// whether someone is capturing events or not
+func (m *M) measure(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		atomic.AddInt64(&m.metrics.inflight, 1)
+		route := m.routeName(req)
+		mw := &metricsWriter{ResponseWriter: w, connect: req.Method == http.MethodConnect, upgrade: isWebSocket(req)}
+		if req.Body != nil {
+			req.Body = &countingBody{ReadCloser: req.Body, n: &m.metrics.bytesIn}
+		}
+		defer func() {
+			atomic.AddInt64(&m.metrics.inflight, -1)
+			atomic.AddInt64(&m.metrics.bytesOut, mw.bytes)
+			status := strconv.Itoa(mw.code)
+			switch {
+			case mw.code != 0:
+			case mw.reset:
+				status = "reset"
+			default:
+				// handlers that never write still answer 200
+				status = strconv.Itoa(http.StatusOK)
+			}
+			m.metrics.observe(requestKey{method: req.Method, route: route, status: status}, time.Since(start))
+		}()
+		next.ServeHTTP(mw, req)
+	})
+}
+
+// routeName is the label used to group requests by upstream
+func (m *M) routeName(req *http.Request) string {
+	if m.Forward {
+		return "forward"
+	}
+	if m.Routes != nil {
+		if r := m.Routes.Match(req); r != nil {
+			return r.Prefix
+		}
+	}
+	return "default"
+}
+
+func (mt *metrics) observe(key requestKey, elapsed time.Duration) {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+	if mt.requests == nil {
+		mt.requests = make(map[requestKey]int64)
+		mt.latency = make(map[string]*histogram)
+	}
+	mt.requests[key]++
+	h := mt.latency[key.route]
+	if h == nil {
+		h = &histogram{counts: make([]int64, len(latencyBuckets))}
+		mt.latency[key.route] = h
+	}
+	secs := elapsed.Seconds()
+	for i, le := range latencyBuckets {
+		if secs <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += secs
+	h.count++
+}
+
+// metricsAPI writes every metric using the Prometheus text format
+func (m *M) metricsAPI(w http.ResponseWriter, req *http.Request) {
+	m.lock.RLock()
+	probes := len(m.probes)
+	m.lock.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	mt := &m.metrics
+	mt.lock.Lock()
+	header(out, "inspector_requests_total", "counter", "Requests handled by the proxy")
+	keys := make([]requestKey, 0, len(mt.requests))
+	for k := range mt.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+	for _, k := range keys {
+		fmt.Fprintf(out, "inspector_requests_total{method=%v,route=%v,status=%v} %v\n",
+			label(k.method), label(k.route), label(k.status), mt.requests[k])
+	}
+
+	header(out, "inspector_request_duration_seconds", "histogram", "Time taken to answer requests, by route")
+	for _, route := range sortedKeys(mt.latency) {
+		h := mt.latency[route]
+		for i, le := range latencyBuckets {
+			fmt.Fprintf(out, "inspector_request_duration_seconds_bucket{route=%v,le=%q} %v\n",
+				label(route), strconv.FormatFloat(le, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(out, "inspector_request_duration_seconds_bucket{route=%v,le=\"+Inf\"} %v\n", label(route), h.count)
+		fmt.Fprintf(out, "inspector_request_duration_seconds_sum{route=%v} %v\n", label(route), strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(out, "inspector_request_duration_seconds_count{route=%v} %v\n", label(route), h.count)
+	}
+	mt.lock.Unlock()
+
+	gauge := func(name, kind, help string, val int64) {
+		header(out, name, kind, help)
+		fmt.Fprintf(out, "%v %v\n", name, val)
+	}
+	gauge("inspector_requests_in_flight", "gauge", "Requests currently being handled", atomic.LoadInt64(&mt.inflight))
+	gauge("inspector_received_bytes_total", "counter", "Request body bytes received from clients", atomic.LoadInt64(&mt.bytesIn))
+	gauge("inspector_sent_bytes_total", "counter", "Response body bytes sent to clients", atomic.LoadInt64(&mt.bytesOut))
+	gauge("inspector_probes", "gauge", "Probes (eg.: dashboards) connected to the request stream", int64(probes))
+	gauge("inspector_dropped_events_total", "counter", "Events not delivered because a probe was too slow", atomic.LoadInt64(&mt.dropped))
+}
+
+func header(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, kind)
+}
+
+// label quotes a label value using the escaping rules of the text format
+func label(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	return `"` + v + `"`
+}
+
+func (w *metricsWriter) WriteHeader(code int) {
+	if w.code == 0 && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *metricsWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Hijack switches protocols, establishes tunnels or resets the
+// connection, the status is recorded accordingly
+func (w *metricsWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil && w.code == 0 {
+		switch {
+		case w.connect:
+			w.code = http.StatusOK
+		case w.upgrade:
+			w.code = http.StatusSwitchingProtocols
+		default:
+			w.reset = true
+		}
+	}
+	return conn, brw, err
+}
+
+// Unwrap allows http.ResponseController to reach the original writer
+func (w *metricsWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (c *countingBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
@@ -0,0 +1,202 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// TagMocked marks events answered by a mock rule instead of the upstream
+const TagMocked = "mocked"
+
+type (
+	// Duration accepts values like "1s" or "250ms" when used in JSON
+	Duration time.Duration
+
+	// MockRule answers requests matching it without calling the upstream,
+	// empty matchers match anything
+	MockRule struct {
+		ID     int64  `json:"id"`
+		Name   string `json:"name,omitempty"`
+		Method string `json:"method,omitempty"`
+		// Path is a regular expression matched against the request path
+		Path string `json:"path,omitempty"`
+		// Headers maps a header name to a regular expression that
+		// must match one of its values
+		Headers map[string]string `json:"headers,omitempty"`
+		// Body is a regular expression matched against the request body
+		Body     string       `json:"body,omitempty"`
+		Response MockResponse `json:"response"`
+
+		path    *regexp.Regexp
+		headers map[string]*regexp.Regexp
+		body    *regexp.Regexp
+	}
+
+	// MockResponse is sent back to the client after Delay
+	MockResponse struct {
+		Status  int         `json:"status,omitempty"`
+		Headers http.Header `json:"headers,omitempty"`
+		Body    string      `json:"body,omitempty"`
+		Delay   Duration    `json:"delay,omitempty"`
+	}
+)
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	val, err := time.ParseDuration(string(text))
+	*d = Duration(val)
+	return err
+}
+
+// LoadMocks reads a JSON file containing a list of mock rules
+func LoadMocks(file string) ([]MockRule, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var rules []MockRule
+	if err := json.Unmarshal(buf, &rules); err != nil {
+		return nil, fmt.Errorf("unable to parse mocks from %v: %w", file, err)
+	}
+	return rules, nil
+}
+
+func (r *MockRule) compile() error {
+	r.Method = strings.ToUpper(r.Method)
+	var err error
+	compile := func(expr string) *regexp.Regexp {
+		if err != nil || expr == "" {
+			return nil
+		}
+		var re *regexp.Regexp
+		re, err = regexp.Compile(expr)
+		return re
+	}
+	r.path = compile(r.Path)
+	r.body = compile(r.Body)
+	r.headers = make(map[string]*regexp.Regexp, len(r.Headers))
+	for name, expr := range r.Headers {
+		r.headers[http.CanonicalHeaderKey(name)] = compile(expr)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid mock %q: %w", r.Name, err)
+	}
+	return nil
+}
+
+// matchHead checks everything except the body
+func (r *MockRule) matchHead(req *http.Request) bool {
+	if r.Method != "" && r.Method != req.Method {
+		return false
+	}
+	if r.path != nil && !r.path.MatchString(req.URL.Path) {
+		return false
+	}
+	for name, re := range r.headers {
+		found := false
+		for _, v := range req.Header.Values(name) {
+			if re == nil || re.MatchString(v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// AddMock starts answering requests matching r
+func (m *M) AddMock(r MockRule) (MockRule, error) {
+	if err := r.compile(); err != nil {
+		return MockRule{}, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.mockcount++
+	r.ID = m.mockcount
+	m.mocks = append(m.mocks, r)
+	return r, nil
+}
+
+// RemoveMock stops using the given mock rule
+func (m *M) RemoveMock(id int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for i, r := range m.mocks {
+		if r.ID == id {
+			m.mocks = append(m.mocks[:i:i], m.mocks[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// Mocks returns every active mock rule, in evaluation order
+func (m *M) Mocks() []MockRule {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return append([]MockRule{}, m.mocks...)
+}
+
+// serveMock answers req using the first matching mock rule,
+// returns false if no rule matched
+func (m *M) serveMock(w http.ResponseWriter, req *http.Request, ev *IOEvent) bool {
+	rules := m.Mocks()
+	var body []byte
+	var bodyRead bool
+	var rule *MockRule
+	for i := range rules {
+		r := &rules[i]
+		if !r.matchHead(req) {
+			continue
+		}
+		if r.body != nil {
+			if !bodyRead {
+				body, _ = io.ReadAll(req.Body)
+				req.Body = io.NopCloser(bytes.NewReader(body))
+				bodyRead = true
+			}
+			if !r.body.Match(body) {
+				continue
+			}
+		}
+		rule = r
+		break
+	}
+	if rule == nil {
+		return false
+	}
+	// consume whatever is left so the request body is captured
+	io.Copy(io.Discard, req.Body)
+	ev.Tags = append(ev.Tags, TagMocked)
+
+	if rule.Response.Delay > 0 {
+		select {
+		case <-time.After(time.Duration(rule.Response.Delay)):
+		case <-req.Context().Done():
+			return true
+		}
+	}
+	for k, vals := range rule.Response.Headers {
+		w.Header()[http.CanonicalHeaderKey(k)] = append([]string(nil), vals...)
+	}
+	status := rule.Response.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	io.WriteString(w, rule.Response.Body)
+	return true
+}
@@ -0,0 +1,154 @@
+package manager
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when the requested event is not available
+	ErrNotFound = errors.New("event not found")
+)
+
+type (
+	// Query selects events from the history or the store,
+	// zero values are ignored
+	Query struct {
+		// FromID and ToID are inclusive
+		FromID int64
+		ToID   int64
+		// Since and Until refer to the moment the request started
+		Since time.Time
+		Until time.Time
+		// Method and Code must match exactly, while URL only needs
+		// to be part of the event URL
+		Method string
+		Code   int
+		URL    string
+		// Limit keeps only the most recent matches
+		Limit int
+		// AfterSeq keeps only the events published after it,
+		// only stores use it (see IOEvent.Seq)
+		AfterSeq int64
+	}
+
+	// Store persists events so they survive restarts
+	Store interface {
+		Append(*IOEvent) error
+		// LastID and LastSeq are used to continue the ID and
+		// publish sequences after a restart
+		LastID() int64
+		LastSeq() int64
+		Get(id int64) (*IOEvent, error)
+		Query(Query) ([]*IOEvent, error)
+	}
+)
+
+// ParseQuery reads a query from the from, to, since, until, method, code,
+// url and limit parameters, times use RFC3339
+func ParseQuery(v url.Values) (Query, error) {
+	var q Query
+	var err error
+	parseInt := func(name string, dst *int64) {
+		if err != nil || v.Get(name) == "" {
+			return
+		}
+		*dst, err = strconv.ParseInt(v.Get(name), 10, 64)
+	}
+	parseTime := func(name string, dst *time.Time) {
+		if err != nil || v.Get(name) == "" {
+			return
+		}
+		*dst, err = time.Parse(time.RFC3339, v.Get(name))
+	}
+	var limit, code int64
+	parseInt("from", &q.FromID)
+	parseInt("to", &q.ToID)
+	parseInt("limit", &limit)
+	parseInt("code", &code)
+	parseTime("since", &q.Since)
+	parseTime("until", &q.Until)
+	q.Limit = int(limit)
+	q.Code = int(code)
+	q.Method = strings.ToUpper(v.Get("method"))
+	q.URL = v.Get("url")
+	return q, err
+}
+
+// MatchID checks if id is within the requested range
+func (q Query) MatchID(id int64) bool {
+	if q.FromID > 0 && id < q.FromID {
+		return false
+	}
+	if q.ToID > 0 && id > q.ToID {
+		return false
+	}
+	return true
+}
+
+// MatchTime checks if t is within the requested time window
+func (q Query) MatchTime(t time.Time) bool {
+	if !q.Since.IsZero() && t.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && t.After(q.Until) {
+		return false
+	}
+	return true
+}
+
+// Match checks if ev satisfies every filter in q
+func (q Query) Match(ev *IOEvent) bool {
+	if !q.MatchID(ev.ID) || !q.MatchTime(ev.Start) {
+		return false
+	}
+	if q.Method != "" && q.Method != ev.Method {
+		return false
+	}
+	if q.Code != 0 && q.Code != ev.Code {
+		return false
+	}
+	if q.URL != "" && !strings.Contains(ev.URL, q.URL) {
+		return false
+	}
+	return true
+}
+
+// Values is the inverse of ParseQuery
+func (q Query) Values() url.Values {
+	v := url.Values{}
+	setInt := func(name string, val int64) {
+		if val != 0 {
+			v.Set(name, strconv.FormatInt(val, 10))
+		}
+	}
+	setTime := func(name string, val time.Time) {
+		if !val.IsZero() {
+			v.Set(name, val.Format(time.RFC3339))
+		}
+	}
+	setInt("from", q.FromID)
+	setInt("to", q.ToID)
+	setInt("limit", int64(q.Limit))
+	setInt("code", int64(q.Code))
+	setTime("since", q.Since)
+	setTime("until", q.Until)
+	if q.Method != "" {
+		v.Set("method", q.Method)
+	}
+	if q.URL != "" {
+		v.Set("url", q.URL)
+	}
+	return v
+}
+
+// Trim applies the limit to events ordered from oldest to newest
+func (q Query) Trim(events []*IOEvent) []*IOEvent {
+	if q.Limit > 0 && len(events) > q.Limit {
+		return events[len(events)-q.Limit:]
+	}
+	return events
+}
@@ -0,0 +1,327 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces every value hidden by a Redactor
+const Redacted = "[REDACTED]"
+
+// DefaultRedactedHeaders are hidden unless redaction defaults are disabled
+var DefaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+type (
+	// Redactor hides sensitive data from events before they
+	// leave the manager (probes, history, store and API)
+	Redactor struct {
+		// Headers lists header (and trailer) names whose values are hidden
+		Headers []string
+		// JSONPaths lists dotted paths (eg.: user.password, items.*.token)
+		// hidden from JSON bodies, * matches any key or array index
+		JSONPaths []string
+		// Patterns are hidden from URLs, header values and bodies
+		Patterns []*regexp.Regexp
+
+		headers map[string]bool
+		paths   [][]string
+	}
+
+	// secrets keeps, for the most recent events, the requests as
+	// they were before redaction so replays still authenticate
+	secrets struct {
+		requests map[int64]*secret
+		order    []int64
+	}
+
+	// secret is a request before redaction, next to what was published
+	// so placeholders left untouched by an edit can be told apart
+	secret struct {
+		header       http.Header
+		url          string
+		body         []byte
+		publishedURL string
+		published    []byte
+	}
+)
+
+// secretsLimit caps how many events keep their original request
+const secretsLimit = 1000
+
+var (
+	// ErrRedacted is returned when sending a request that still holds
+	// values hidden by redaction whose originals are no longer known
+	ErrRedacted = errors.New("request holds redacted values that are no longer known")
+)
+
+// NewRedactor validates the rules and returns a ready to use redactor,
+// when defaults is true DefaultRedactedHeaders are included
+func NewRedactor(defaults bool, headers, jsonPaths, patterns []string) (*Redactor, error) {
+	r := &Redactor{JSONPaths: jsonPaths}
+	if defaults {
+		r.Headers = append(r.Headers, DefaultRedactedHeaders...)
+	}
+	r.Headers = append(r.Headers, headers...)
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", p, err)
+		}
+		r.Patterns = append(r.Patterns, re)
+	}
+	r.compile()
+	return r, nil
+}
+
+func (r *Redactor) compile() {
+	r.headers = make(map[string]bool, len(r.Headers))
+	for _, h := range r.Headers {
+		r.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	r.paths = r.paths[:0]
+	for _, p := range r.JSONPaths {
+		r.paths = append(r.paths, strings.Split(p, "."))
+	}
+}
+
+// Apply hides sensitive data from ev, changing it in place
+func (r *Redactor) Apply(ev *IOEvent) {
+	if r.headers == nil {
+		r.compile()
+	}
+	ev.URL = r.text(ev.URL)
+	for _, msg := range []*Message{&ev.Request, &ev.Response} {
+		r.header(msg.Headers)
+		r.header(msg.Trailers)
+		if msg.IsText() {
+			msg.Body = r.body(msg.Body, msg.Truncated)
+		}
+		if msg.Decoded != nil && isText(msg.ContentType(), msg.Decoded) {
+			decoded := r.body(msg.Decoded, msg.Truncated)
+			if !bytes.Equal(decoded, msg.Decoded) {
+				msg.Decoded = decoded
+				// the encoded bytes would still hold the hidden values
+				msg.Body = nil
+			}
+		}
+	}
+	if ev.Frame != nil && ev.Frame.IsText() {
+		ev.Frame.Payload = r.body(ev.Frame.Payload, ev.Frame.Truncated)
+	}
+	if ev.GRPC != nil {
+		if r.messages(ev.GRPC.Requests) {
+			// the protobuf encoded body would still hold the hidden values
+			ev.Request.Body, ev.Request.Decoded = nil, nil
+		}
+		if r.messages(ev.GRPC.Responses) {
+			ev.Response.Body, ev.Response.Decoded = nil, nil
+		}
+	}
+}
+
+// body hides sensitive data from a text body, a truncated JSON document
+// cannot be parsed so it is dropped instead of leaking the hidden paths
+func (r *Redactor) body(b []byte, truncated bool) []byte {
+	if truncated && len(r.paths) > 0 && isJSONText(string(b)) {
+		return nil
+	}
+	return []byte(r.text(r.json(string(b))))
+}
+
+// messages redacts decoded gRPC messages in place, reporting changes
+func (r *Redactor) messages(msgs []json.RawMessage) bool {
+	changed := false
+	for i, msg := range msgs {
+		redacted := r.text(r.json(string(msg)))
+		if redacted != string(msg) {
+			msgs[i] = json.RawMessage(redacted)
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (r *Redactor) header(h http.Header) {
+	for k, vals := range h {
+		if r.headers[http.CanonicalHeaderKey(k)] {
+			for i := range vals {
+				vals[i] = Redacted
+			}
+			continue
+		}
+		for i, v := range vals {
+			vals[i] = r.text(v)
+		}
+	}
+}
+
+func (r *Redactor) text(s string) string {
+	for _, re := range r.Patterns {
+		s = re.ReplaceAllString(s, Redacted)
+	}
+	return s
+}
+
+// json hides the configured paths when body is a JSON document,
+// anything else is returned unchanged
+func (r *Redactor) json(body string) string {
+	if len(r.paths) == 0 || body == "" {
+		return body
+	}
+	if !isJSONText(body) {
+		return body
+	}
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return body
+	}
+	changed := false
+	for _, p := range r.paths {
+		changed = redactPath(doc, p) || changed
+	}
+	if !changed {
+		return body
+	}
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return body
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func isJSONText(body string) bool {
+	trimmed := strings.TrimSpace(body)
+	return strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")
+}
+
+func redactPath(doc any, path []string) bool {
+	if len(path) == 0 {
+		return false
+	}
+	changed := false
+	switch v := doc.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if path[0] != "*" && path[0] != k {
+				continue
+			}
+			if len(path) == 1 {
+				v[k] = Redacted
+				changed = true
+			} else {
+				changed = redactPath(child, path[1:]) || changed
+			}
+		}
+	case []any:
+		for i, child := range v {
+			if path[0] != "*" && path[0] != fmt.Sprint(i) {
+				continue
+			}
+			if len(path) == 1 {
+				v[i] = Redacted
+				changed = true
+			} else {
+				changed = redactPath(child, path[1:]) || changed
+			}
+		}
+	}
+	return changed
+}
+
+// hasRedacted reports if any value of h was hidden, even partially
+func hasRedacted(h http.Header) bool {
+	for _, vals := range h {
+		for _, v := range vals {
+			if strings.Contains(v, Redacted) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *secrets) keep(id int64, r *secret) {
+	if s.requests == nil {
+		s.requests = make(map[int64]*secret)
+	}
+	if len(s.order) >= secretsLimit {
+		delete(s.requests, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.requests[id] = r
+	s.order = append(s.order, id)
+}
+
+// get never returns nil, an unknown event has nothing to restore
+func (s *secrets) get(id int64) (*secret, bool) {
+	if r, ok := s.requests[id]; ok {
+		return r, true
+	}
+	return &secret{}, false
+}
+
+// unredact returns the original value when edited is still the published
+// one, any other text holding a placeholder cannot be sent
+func unredact(edited, published, orig string) (string, error) {
+	if sameText(edited, published) {
+		return orig, nil
+	}
+	if strings.Contains(edited, Redacted) {
+		return "", ErrRedacted
+	}
+	return edited, nil
+}
+
+// sameText ignores the line breaks changed by HTML forms
+func sameText(a, b string) bool {
+	return strings.ReplaceAll(a, "\r\n", "\n") == strings.ReplaceAll(b, "\r\n", "\n")
+}
+
+// restoreRedacted puts back the original values of headers that still
+// hold the Redacted placeholder (eg.: after an edit from the dashboard)
+func restoreRedacted(edited, orig http.Header) {
+	for k, vals := range edited {
+		for i, v := range vals {
+			if !strings.Contains(v, Redacted) {
+				continue
+			}
+			if o := orig.Values(k); i < len(o) {
+				vals[i] = o[i]
+			}
+		}
+	}
+}
+
+// dropRedacted removes the values hidden by redaction, sending the
+// placeholder upstream would be worse. Returns the names of the
+// headers that lost a value
+func dropRedacted(h http.Header) []string {
+	var dropped []string
+	for _, k := range sortedKeys(h) {
+		vals := h[k]
+		kept := vals[:0]
+		for _, v := range vals {
+			if !strings.Contains(v, Redacted) {
+				kept = append(kept, v)
+			}
+		}
+		if len(kept) < len(vals) {
+			dropped = append(dropped, k)
+		}
+		if len(kept) == 0 {
+			delete(h, k)
+		} else {
+			h[k] = kept
+		}
+	}
+	return dropped
+}
@@ -0,0 +1,147 @@
+package manager
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+)
+
+func TestRedactorJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		body  string
+		want  string
+	}{
+		{name: "not json", paths: []string{"a"}, body: "a=1", want: "a=1"},
+		{name: "invalid json", paths: []string{"a"}, body: `{"a":`, want: `{"a":`},
+		{name: "no match keeps formatting", paths: []string{"b"}, body: `{ "a": 1 }`, want: `{ "a": 1 }`},
+		{name: "top level", paths: []string{"password"}, body: `{"user":"ann","password":"x"}`, want: `{"password":"[REDACTED]","user":"ann"}`},
+		{name: "nested", paths: []string{"user.password"}, body: `{"user":{"password":"x","name":"ann"}}`, want: `{"user":{"name":"ann","password":"[REDACTED]"}}`},
+		{name: "whole object", paths: []string{"user"}, body: `{"user":{"password":"x"}}`, want: `{"user":"[REDACTED]"}`},
+		{name: "wildcard index", paths: []string{"items.*.token"}, body: `{"items":[{"token":"a"},{"token":"b","id":1}]}`, want: `{"items":[{"token":"[REDACTED]"},{"id":1,"token":"[REDACTED]"}]}`},
+		{name: "explicit index", paths: []string{"1"}, body: `["a","b"]`, want: `["a","[REDACTED]"]`},
+		{name: "wildcard key", paths: []string{"*.secret"}, body: `{"a":{"secret":1},"b":{"secret":2}}`, want: `{"a":{"secret":"[REDACTED]"},"b":{"secret":"[REDACTED]"}}`},
+		{name: "large numbers survive", paths: []string{"a"}, body: `{"a":1,"id":12345678901234567890}`, want: `{"a":"[REDACTED]","id":12345678901234567890}`},
+		{name: "missing path", paths: []string{"a.b.c"}, body: `{"a":{"b":1}}`, want: `{"a":{"b":1}}`},
+	}
+	for _, tt := range tests {
+		r, err := NewRedactor(false, nil, tt.paths, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.json(tt.body); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRedactorApply(t *testing.T) {
+	r := &Redactor{
+		Headers:  []string{"authorization"},
+		Patterns: []*regexp.Regexp{regexp.MustCompile(`token=\w+`)},
+	}
+	ev := &IOEvent{URL: "/x?token=abc"}
+	ev.Request.Headers = map[string][]string{"Authorization": {"Bearer x"}, "X-Note": {"token=abc"}}
+	ev.Request.Body = []byte("token=abc&b=1")
+	ev.Response.Headers = map[string][]string{"Content-Type": {"image/png"}}
+	ev.Response.Body = []byte("token=abc")
+	r.Apply(ev)
+	if ev.URL != "/x?[REDACTED]" {
+		t.Errorf("url is %v", ev.URL)
+	}
+	if got := ev.Request.Headers.Get("Authorization"); got != Redacted {
+		t.Errorf("authorization is %v", got)
+	}
+	if got := ev.Request.Headers.Get("X-Note"); got != Redacted {
+		t.Errorf("x-note is %v", got)
+	}
+	if got := string(ev.Request.Body); got != "[REDACTED]&b=1" {
+		t.Errorf("request body is %v", got)
+	}
+	// binary bodies are left alone
+	if got := string(ev.Response.Body); got != "token=abc" {
+		t.Errorf("response body is %v", got)
+	}
+}
+
+func TestUnredact(t *testing.T) {
+	tests := []struct {
+		name                    string
+		edited, published, orig string
+		want                    string
+		err                     error
+	}{
+		{name: "untouched", edited: "/x?[REDACTED]", published: "/x?[REDACTED]", orig: "/x?token=a", want: "/x?token=a"},
+		{name: "form line breaks", edited: "a\r\n[REDACTED]", published: "a\n[REDACTED]", orig: "a\nb", want: "a\nb"},
+		{name: "edited without placeholder", edited: "/y", published: "/x?[REDACTED]", orig: "/x?token=a", want: "/y"},
+		{name: "edited with placeholder", edited: "/y?[REDACTED]", published: "/x?[REDACTED]", orig: "/x?token=a", err: ErrRedacted},
+		{name: "unknown original", edited: "/x?[REDACTED]", err: ErrRedacted},
+		{name: "nothing redacted", edited: "/x", want: "/x"},
+	}
+	for _, tt := range tests {
+		got, err := unredact(tt.edited, tt.published, tt.orig)
+		if err != tt.err || got != tt.want {
+			t.Errorf("%v: got %q %v, want %q %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestRedactorDropsBodies(t *testing.T) {
+	r, err := NewRedactor(false, nil, []string{"password"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonType := map[string][]string{"Content-Type": {"application/json"}}
+	tests := []struct {
+		name string
+		ev   IOEvent
+		body string
+		grpc string
+	}{
+		{
+			name: "complete json",
+			ev:   IOEvent{Request: Message{Headers: jsonType, Body: []byte(`{"password":"x"}`)}},
+			body: `{"password":"[REDACTED]"}`,
+		},
+		{
+			name: "truncated json",
+			ev:   IOEvent{Request: Message{Headers: jsonType, Body: []byte(`{"password":"x","na`), Truncated: true}},
+			body: "",
+		},
+		{
+			name: "truncated text",
+			ev:   IOEvent{Request: Message{Headers: map[string][]string{"Content-Type": {"text/plain"}}, Body: []byte("password=x"), Truncated: true}},
+			body: "password=x",
+		},
+		{
+			name: "redacted grpc",
+			ev: IOEvent{
+				Request: Message{Body: []byte{0, 0, 0, 0, 3, 1, 2, 3}},
+				GRPC:    &GRPC{Requests: []json.RawMessage{json.RawMessage(`{"password":"x"}`)}},
+			},
+			body: "",
+			grpc: `{"password":"[REDACTED]"}`,
+		},
+		{
+			name: "untouched grpc",
+			ev: IOEvent{
+				Request: Message{Body: []byte{0, 0, 0, 0, 1, 1}},
+				GRPC:    &GRPC{Requests: []json.RawMessage{json.RawMessage(`{"user":"x"}`)}},
+			},
+			body: "\x00\x00\x00\x00\x01\x01",
+			grpc: `{"user":"x"}`,
+		},
+	}
+	for _, tt := range tests {
+		r.Apply(&tt.ev)
+		if got := string(tt.ev.Request.Body); got != tt.body {
+			t.Errorf("%v: body is %q, want %q", tt.name, got, tt.body)
+		}
+		if tt.ev.GRPC != nil {
+			if got := string(tt.ev.GRPC.Requests[0]); got != tt.grpc {
+				t.Errorf("%v: grpc message is %v, want %v", tt.name, got, tt.grpc)
+			}
+		}
+	}
+}
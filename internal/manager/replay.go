@@ -0,0 +1,136 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+const (
+	// TagReplay marks events created by Replay
+	TagReplay = "replay"
+	// TagComposed marks events created by Compose
+	TagComposed = "composed"
+)
+
+type (
+	// Composition is a request written (or tweaked) by hand
+	Composition struct {
+		Method  string      `json:"method"`
+		URL     string      `json:"url"`
+		Host    string      `json:"host,omitempty"`
+		Headers http.Header `json:"headers"`
+		Body    string      `json:"body"`
+		// From is the event the composition started from, used to
+		// restore the values hidden by redaction
+		From int64 `json:"from,omitempty"`
+	}
+)
+
+var (
+	// ErrTruncatedBody is returned when replaying an event whose
+	// request body was not fully captured
+	ErrTruncatedBody = errors.New("request body was truncated during capture")
+)
+
+// Replay sends the request captured by the event with the given id
+// to the upstream once again, the new exchange is published as a
+// fresh event linked to the original one
+func (m *M) Replay(ctx context.Context, id int64) (*IOEvent, error) {
+	orig, err := m.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	if orig.Request.Truncated {
+		return nil, ErrTruncatedBody
+	}
+	s, known := m.secret(orig.ID)
+	target, body, header := orig.URL, orig.Request.Body, orig.Request.Headers.Clone()
+	if known {
+		target, body = s.url, s.body
+	} else if body == nil && orig.Request.Decoded != nil {
+		// redaction dropped the encoded body, only the decoded one is left
+		body = orig.Request.Decoded
+		header.Del("Content-Encoding")
+		header.Del("Content-Length")
+	} else if body == nil && orig.Request.Size > 0 {
+		// redaction dropped the body altogether
+		return nil, ErrRedacted
+	}
+	if strings.Contains(target, Redacted) || bytes.Contains(body, []byte(Redacted)) {
+		return nil, ErrRedacted
+	}
+	req, err := http.NewRequestWithContext(ctx, orig.Method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	restoreRedacted(header, s.header)
+	req.Header = header
+	dropped := dropRedacted(req.Header)
+	req.Host = orig.Host
+	return m.issue(req, func(ev *IOEvent) {
+		ev.ReplayOf = orig.ID
+		ev.Tags = append(ev.Tags, TagReplay)
+		ev.DroppedHeaders = dropped
+	}), nil
+}
+
+// Compose sends c to the upstream, the exchange is published
+// like any other request
+func (m *M) Compose(ctx context.Context, c Composition) (*IOEvent, error) {
+	if c.Method == "" {
+		c.Method = http.MethodGet
+	}
+	s, _ := m.secret(c.From)
+	target, err := unredact(c.URL, s.publishedURL, s.url)
+	if err != nil {
+		return nil, err
+	}
+	body, err := unredact(c.Body, string(s.published), string(s.body))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, c.Method, target, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if c.Headers != nil {
+		req.Header = c.Headers.Clone()
+	}
+	restoreRedacted(req.Header, s.header)
+	dropped := dropRedacted(req.Header)
+	if c.Host != "" {
+		req.Host = c.Host
+	}
+	return m.issue(req, func(ev *IOEvent) {
+		ev.Tags = append(ev.Tags, TagComposed)
+		ev.DroppedHeaders = dropped
+	}), nil
+}
+
+// secret returns the request, before redaction, of the event with the
+// given id. It is empty when the event had nothing hidden or is too old
+func (m *M) secret(id int64) (*secret, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.secrets.get(id)
+}
+
+// issue sends a request created by the manager itself to the upstream,
+// tag can change the event before it gets published
+func (m *M) issue(req *http.Request, tag func(*IOEvent)) (ev *IOEvent) {
+	ev, reqBody := m.inspectRequest(req)
+	tag(ev)
+	res := &captureWriter{ResponseWriter: httptest.NewRecorder(), body: newLimitedBuffer(m.bodyLimit())}
+	defer func() {
+		if r := recover(); r != nil && r != http.ErrAbortHandler {
+			panic(r)
+		}
+		m.inspectResponse(ev, reqBody, res)
+	}()
+	m.forward(res, req, ev)
+	return ev
+}
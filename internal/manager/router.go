@@ -0,0 +1,177 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+type (
+	// Route sends requests whose path starts with Prefix to Target
+	Route struct {
+		Prefix string
+		Target *url.URL
+		// Strip removes Prefix from the path before forwarding
+		Strip bool
+
+		proxy *httputil.ReverseProxy
+	}
+
+	// Router picks the route with the longest matching prefix
+	Router struct {
+		routes []*Route
+	}
+)
+
+// ParseRoute reads a route in the form prefix=url[,strip]
+// (eg.: /api=http://localhost:9000,strip)
+func ParseRoute(spec string) (*Route, error) {
+	prefix, target, ok := strings.Cut(spec, "=")
+	if !ok || prefix == "" {
+		return nil, fmt.Errorf("invalid route %q, expecting prefix=url[,strip]", spec)
+	}
+	target, opt, _ := strings.Cut(target, ",")
+	var strip bool
+	switch opt {
+	case "":
+	case "strip":
+		strip = true
+	default:
+		return nil, fmt.Errorf("invalid route option %q in %q", opt, spec)
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid route target in %q: %w", spec, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("route target in %q must be an absolute URL", spec)
+	}
+	return NewRoute(prefix, u, strip), nil
+}
+
+// NewRoute returns a route forwarding to target using a single host reverse proxy
+func NewRoute(prefix string, target *url.URL, strip bool) *Route {
+	r := &Route{Prefix: prefix, Target: target, Strip: strip}
+	r.proxy = httputil.NewSingleHostReverseProxy(target)
+	r.proxy.ModifyResponse = recordUpstream
+	if strip {
+		director := r.proxy.Director
+		r.proxy.Director = func(req *http.Request) {
+			req.URL.Path = "/" + strings.TrimLeft(strings.TrimPrefix(req.URL.Path, prefix), "/")
+			req.URL.RawPath = ""
+			director(req)
+		}
+	}
+	return r
+}
+
+// Proxy exposes the reverse proxy used by the route, so callers
+// can tweak it (eg.: Transport, ErrorLog)
+func (r *Route) Proxy() *httputil.ReverseProxy {
+	return r.proxy
+}
+
+func (r *Route) match(path string) bool {
+	return matchPrefix(path, r.Prefix)
+}
+
+// matchPrefix only accepts whole path segments, so /api does not match /apiary
+func matchPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || prefix == "" || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// NewRouter returns a router using all the given routes
+func NewRouter(routes ...*Route) *Router {
+	rt := &Router{routes: append([]*Route(nil), routes...)}
+	sort.SliceStable(rt.routes, func(i, j int) bool {
+		return len(rt.routes[i].Prefix) > len(rt.routes[j].Prefix)
+	})
+	return rt
+}
+
+// Routes returns all routes, longest prefix first
+func (rt *Router) Routes() []*Route {
+	return append([]*Route(nil), rt.routes...)
+}
+
+// Match returns the route for req or nil if none matches
+func (rt *Router) Match(req *http.Request) *Route {
+	for _, r := range rt.routes {
+		if r.match(req.URL.Path) {
+			return r
+		}
+	}
+	return nil
+}
+
+// forward sends req to the upstream chosen for it, either the host in the
+// URL (forward proxy), a route or the default Upstream, and records the
+// choice on ev (when not nil)
+func (m *M) forward(w http.ResponseWriter, req *http.Request, ev *IOEvent) {
+	var upstream http.Handler
+	var name string
+	if m.Forward && req.URL.IsAbs() {
+		upstream, name = m.forwardProxy(), req.URL.Scheme+"://"+req.URL.Host
+	} else if m.Routes != nil {
+		if r := m.Routes.Match(req); r != nil {
+			upstream, name = r.proxy, r.Target.String()
+		}
+	}
+	if upstream == nil && m.Upstream != nil {
+		upstream = m.Upstream
+	}
+	if ev != nil {
+		ev.Upstream = name
+		req = req.WithContext(context.WithValue(req.Context(), eventKey, ev))
+		// upgraded connections are published before the upstream is done
+		if !isWebSocket(req) {
+			t := &tracer{}
+			req = t.trace(req)
+			defer func() { ev.Timings = t.timings() }()
+		}
+	}
+	if upstream == nil {
+		http.Error(w, "no upstream configured for this path", http.StatusBadGateway)
+		return
+	}
+	upstream.ServeHTTP(w, req)
+}
+
+// NewTransport returns a transport using proto to reach upstreams, options are:
+// auto (HTTP/1.1, or HTTP/2 when negotiated over TLS), h1, h2 (HTTP/2 over TLS)
+// and h2c (HTTP/2 without TLS for http:// upstreams)
+func NewTransport(proto string) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Protocols = new(http.Protocols)
+	switch proto {
+	case "", "auto":
+		t.Protocols.SetHTTP1(true)
+		t.Protocols.SetHTTP2(true)
+	case "h1":
+		t.Protocols.SetHTTP1(true)
+	case "h2":
+		t.Protocols.SetHTTP2(true)
+	case "h2c":
+		t.Protocols.SetHTTP2(true)
+		t.Protocols.SetUnencryptedHTTP2(true)
+	default:
+		return nil, fmt.Errorf("invalid upstream protocol: %v", proto)
+	}
+	return t, nil
+}
+
+// recordUpstream saves the protocol negotiated with the upstream
+// on the event being captured, if any
+func recordUpstream(res *http.Response) error {
+	if ev, ok := res.Request.Context().Value(eventKey).(*IOEvent); ok {
+		ev.UpstreamProto = res.Proto
+	}
+	return nil
+}
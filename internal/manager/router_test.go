@@ -0,0 +1,66 @@
+package manager
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	var routes []*Route
+	for _, spec := range []string{
+		"/api=http://api:9000,strip",
+		"/api/v2=http://v2:9000/base",
+		"/static/=http://cdn:80,strip",
+		"/ws=http://ws:80",
+	} {
+		r, err := ParseRoute(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		routes = append(routes, r)
+	}
+	rt := NewRouter(routes...)
+	tests := []struct {
+		path string
+		// host and path sent upstream, empty host when nothing matches
+		host, upstream string
+	}{
+		{path: "/api", host: "api:9000", upstream: "/"},
+		{path: "/api/users", host: "api:9000", upstream: "/users"},
+		{path: "/api//users", host: "api:9000", upstream: "/users"},
+		{path: "/apiary", host: ""},
+		{path: "/api/v2", host: "v2:9000", upstream: "/base/api/v2"},
+		{path: "/api/v2/x", host: "v2:9000", upstream: "/base/api/v2/x"},
+		{path: "/api/v20", host: "api:9000", upstream: "/v20"},
+		{path: "/static/a.css", host: "cdn:80", upstream: "/a.css"},
+		{path: "/static", host: ""},
+		{path: "/ws", host: "ws:80", upstream: "/ws"},
+		{path: "/", host: ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		r := rt.Match(req)
+		if r == nil {
+			if tt.host != "" {
+				t.Errorf("%v: no route, want %v", tt.path, tt.host)
+			}
+			continue
+		}
+		if r.Target.Host != tt.host {
+			t.Errorf("%v: routed to %v, want %v", tt.path, r.Target.Host, tt.host)
+			continue
+		}
+		r.Proxy().Director(req)
+		if req.URL.Path != tt.upstream {
+			t.Errorf("%v: sent upstream as %v, want %v", tt.path, req.URL.Path, tt.upstream)
+		}
+	}
+}
+
+func TestParseRoute(t *testing.T) {
+	for _, spec := range []string{"", "/api", "=http://a", "/api=http://a,nope", "/api=localhost:9000", "/api=/relative"} {
+		if _, err := ParseRoute(spec); err == nil {
+			t.Errorf("%q: expecting an error", spec)
+		}
+	}
+}
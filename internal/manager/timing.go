@@ -0,0 +1,145 @@
+package manager
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+type (
+	// Timings breaks down the time spent on the upstream call, phases
+	// skipped (eg.: DNS for an IP or everything but TTFB on a reused
+	// connection) are zero
+	Timings struct {
+		DNS     Duration `json:"dns,omitempty"`
+		Connect Duration `json:"connect,omitempty"`
+		TLS     Duration `json:"tls,omitempty"`
+		// TTFB goes from the start of the upstream call until the
+		// first byte of the response
+		TTFB Duration `json:"ttfb"`
+		// Total goes until the whole response was sent to the client
+		Total  Duration `json:"total"`
+		Reused bool     `json:"reused,omitempty"`
+	}
+
+	// TimingPhase is a bar of the timing waterfall, Offset and
+	// Width are percentages of the total duration
+	TimingPhase struct {
+		Name     string
+		Duration Duration
+		Offset   float64
+		Width    float64
+	}
+
+	// tracer collects httptrace callbacks, which might run on other goroutines
+	tracer struct {
+		lock                   sync.Mutex
+		start                  time.Time
+		dnsStart, dnsDone      time.Time
+		connectStart, connDone time.Time
+		tlsStart, tlsDone      time.Time
+		firstByte              time.Time
+		reused                 bool
+	}
+)
+
+// Wait is the time the upstream took to answer once the connection was ready
+func (t *Timings) Wait() Duration {
+	wait := t.TTFB - t.DNS - t.Connect - t.TLS
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// Receive is the time spent sending the response body
+func (t *Timings) Receive() Duration {
+	if t.Total < t.TTFB {
+		return 0
+	}
+	return t.Total - t.TTFB
+}
+
+// Waterfall lays out the phases one after the other
+func (t *Timings) Waterfall() []TimingPhase {
+	if t.Total <= 0 {
+		return nil
+	}
+	var out []TimingPhase
+	var offset Duration
+	for _, p := range []struct {
+		name string
+		d    Duration
+	}{
+		{"DNS", t.DNS}, {"Connect", t.Connect}, {"TLS", t.TLS},
+		{"Wait", t.Wait()}, {"Receive", t.Receive()},
+	} {
+		out = append(out, TimingPhase{
+			Name:     p.name,
+			Duration: p.d,
+			Offset:   100 * float64(offset) / float64(t.Total),
+			Width:    100 * float64(p.d) / float64(t.Total),
+		})
+		offset += p.d
+	}
+	return out
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// trace returns req with a ClientTrace that records the upstream timings
+func (t *tracer) trace(req *http.Request) *http.Request {
+	t.start = time.Now()
+	now := func(field *time.Time) {
+		t.lock.Lock()
+		if field.IsZero() {
+			*field = time.Now()
+		}
+		t.lock.Unlock()
+	}
+	last := func(field *time.Time) {
+		t.lock.Lock()
+		*field = time.Now()
+		t.lock.Unlock()
+	}
+	ct := &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { now(&t.dnsStart) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { last(&t.dnsDone) },
+		ConnectStart:      func(string, string) { now(&t.connectStart) },
+		ConnectDone:       func(string, string, error) { last(&t.connDone) },
+		TLSHandshakeStart: func() { now(&t.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { last(&t.tlsDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.lock.Lock()
+			t.reused = info.Reused
+			t.lock.Unlock()
+		},
+		GotFirstResponseByte: func() { now(&t.firstByte) },
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), ct))
+}
+
+// timings summarizes what was recorded, called once the upstream is done
+func (t *tracer) timings() *Timings {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	span := func(start, end time.Time) Duration {
+		if start.IsZero() || end.Before(start) {
+			return 0
+		}
+		return Duration(end.Sub(start))
+	}
+	out := &Timings{
+		DNS:     span(t.dnsStart, t.dnsDone),
+		Connect: span(t.connectStart, t.connDone),
+		TLS:     span(t.tlsStart, t.tlsDone),
+		TTFB:    span(t.start, t.firstByte),
+		Total:   Duration(time.Since(t.start)),
+		Reused:  t.reused,
+	}
+	return out
+}
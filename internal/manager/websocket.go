@@ -0,0 +1,248 @@
+package manager
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TagWebSocket marks upgrade requests whose frames are captured as child events
+const TagWebSocket = "websocket"
+
+const (
+	// FromClient and FromServer tell the direction of a WebSocket frame
+	FromClient = "client"
+	FromServer = "server"
+)
+
+type (
+	// Frame is a single WebSocket frame, captured as a child event
+	// of the upgrade request
+	Frame struct {
+		Direction string `json:"direction"`
+		Opcode    int    `json:"opcode"`
+		Fin       bool   `json:"fin"`
+		// Length is the full payload length, Payload might be shorter
+		// when it exceeds the capture limit
+		Length    int64  `json:"length"`
+		Payload   []byte `json:"payload"`
+		Truncated bool   `json:"truncated,omitempty"`
+	}
+
+	// frameParser decodes frames from a stream one chunk at a time,
+	// without keeping more than the capture limit of each payload
+	frameParser struct {
+		direction string
+		limit     int64
+		emit      func(*Frame)
+
+		head      []byte
+		frame     *Frame
+		mask      []byte
+		remaining int64
+		offset    int64
+		payload   bytes.Buffer
+	}
+
+	// wsConn captures the frames exchanged over a hijacked connection,
+	// reads come from the client and writes go to it
+	wsConn struct {
+		net.Conn
+		r *bufio.Reader
+
+		// the 101 response is written through wsConn as well,
+		// frames only start after it
+		lock      sync.Mutex
+		handshake []byte
+		upgraded  func()
+
+		fromClient *frameParser
+		fromServer *frameParser
+	}
+)
+
+// OpcodeName returns the name of a WebSocket opcode (eg.: text, binary, close)
+func (f *Frame) OpcodeName() string {
+	switch f.Opcode {
+	case 0x0:
+		return "continuation"
+	case 0x1:
+		return "text"
+	case 0x2:
+		return "binary"
+	case 0x8:
+		return "close"
+	case 0x9:
+		return "ping"
+	case 0xA:
+		return "pong"
+	}
+	return "unknown"
+}
+
+func isWebSocket(req *http.Request) bool {
+	return strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+}
+
+// captureWebSocket wraps the hijacked connection so every frame is published
+// as a child of ev, ev itself is published once the handshake completes
+func (m *M) captureWebSocket(ev *IOEvent, reqBody *limitedBuffer, res *captureWriter) func(net.Conn, *bufio.ReadWriter) (net.Conn, *bufio.ReadWriter) {
+	return func(conn net.Conn, brw *bufio.ReadWriter) (net.Conn, *bufio.ReadWriter) {
+		publish := func(direction string) func(*Frame) {
+			return func(f *Frame) {
+				m.publish(&IOEvent{
+					ID:     m.nextID(),
+					Start:  time.Now(),
+					Method: ev.Method,
+					Host:   ev.Host,
+					URL:    ev.URL,
+					Parent: ev.ID,
+					Frame:  f,
+				})
+			}
+		}
+		ws := &wsConn{
+			Conn: conn,
+			r:    brw.Reader,
+			upgraded: func() {
+				res.code = http.StatusSwitchingProtocols
+				res.header = res.ResponseWriter.Header().Clone()
+				ev.Tags = append(ev.Tags, TagWebSocket)
+				m.inspectResponse(ev, reqBody, res)
+			},
+			fromClient: &frameParser{direction: FromClient, limit: m.bodyLimit(), emit: publish(FromClient)},
+			fromServer: &frameParser{direction: FromServer, limit: m.bodyLimit(), emit: publish(FromServer)},
+		}
+		return ws, bufio.NewReadWriter(bufio.NewReader(ws), bufio.NewWriter(ws))
+	}
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	// r might already hold bytes sent by the client
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.fromClient.write(p[:n])
+	}
+	return n, err
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n <= 0 {
+		return n, err
+	}
+	data := p[:n]
+	c.lock.Lock()
+	if c.upgraded != nil {
+		c.handshake = append(c.handshake, data...)
+		end := bytes.Index(c.handshake, []byte("\r\n\r\n"))
+		if end < 0 {
+			c.lock.Unlock()
+			return n, err
+		}
+		data = c.handshake[end+4:]
+		c.handshake = nil
+		c.upgraded()
+		c.upgraded = nil
+	}
+	c.lock.Unlock()
+	c.fromServer.write(data)
+	return n, err
+}
+
+// write consumes p, emitting every frame completed by it
+func (f *frameParser) write(p []byte) {
+	for len(p) > 0 {
+		if f.frame == nil {
+			p = f.readHead(p)
+			continue
+		}
+		chunk := p
+		if int64(len(chunk)) > f.remaining {
+			chunk = chunk[:f.remaining]
+		}
+		p = p[len(chunk):]
+		if room := f.limit - int64(f.payload.Len()); room > 0 {
+			keep := chunk
+			if int64(len(keep)) > room {
+				keep = keep[:room]
+			}
+			start := f.payload.Len()
+			f.payload.Write(keep)
+			if f.mask != nil {
+				buf := f.payload.Bytes()[start:]
+				for i := range buf {
+					buf[i] ^= f.mask[(f.offset+int64(i))%4]
+				}
+			}
+		}
+		f.offset += int64(len(chunk))
+		f.remaining -= int64(len(chunk))
+		if f.remaining == 0 {
+			f.finish()
+		}
+	}
+}
+
+// readHead accumulates the frame header and returns what is left of p
+func (f *frameParser) readHead(p []byte) []byte {
+	for len(p) > 0 {
+		f.head = append(f.head, p[0])
+		p = p[1:]
+		size := 2
+		if len(f.head) >= 2 {
+			switch f.head[1] & 0x7f {
+			case 126:
+				size += 2
+			case 127:
+				size += 8
+			}
+			if f.head[1]&0x80 != 0 {
+				size += 4
+			}
+		}
+		if len(f.head) < size {
+			continue
+		}
+		f.frame = &Frame{
+			Direction: f.direction,
+			Fin:       f.head[0]&0x80 != 0,
+			Opcode:    int(f.head[0] & 0x0f),
+		}
+		rest := f.head[2:]
+		switch f.head[1] & 0x7f {
+		case 126:
+			f.frame.Length = int64(binary.BigEndian.Uint16(rest))
+			rest = rest[2:]
+		case 127:
+			f.frame.Length = int64(binary.BigEndian.Uint64(rest) & (1<<63 - 1))
+			rest = rest[8:]
+		default:
+			f.frame.Length = int64(f.head[1] & 0x7f)
+		}
+		f.mask = nil
+		if f.head[1]&0x80 != 0 {
+			f.mask = append([]byte(nil), rest[:4]...)
+		}
+		f.remaining, f.offset = f.frame.Length, 0
+		f.payload.Reset()
+		f.head = f.head[:0]
+		if f.remaining == 0 {
+			f.finish()
+		}
+		return p
+	}
+	return p
+}
+
+func (f *frameParser) finish() {
+	f.frame.Payload = append([]byte(nil), f.payload.Bytes()...)
+	f.frame.Truncated = int64(f.payload.Len()) < f.frame.Length
+	f.emit(f.frame)
+	f.frame = nil
+}
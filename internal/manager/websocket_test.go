@@ -0,0 +1,126 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// encodeFrame builds a single WebSocket frame, masked when mask is not nil
+func encodeFrame(fin bool, opcode byte, payload, mask []byte) []byte {
+	var out []byte
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	out = append(out, b0)
+	var maskBit byte
+	if mask != nil {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		out = append(out, maskBit|byte(n))
+	case n <= 0xffff:
+		out = append(out, maskBit|126)
+		out = binary.BigEndian.AppendUint16(out, uint16(n))
+	default:
+		out = append(out, maskBit|127)
+		out = binary.BigEndian.AppendUint64(out, uint64(n))
+	}
+	if mask == nil {
+		return append(out, payload...)
+	}
+	out = append(out, mask...)
+	for i, b := range payload {
+		out = append(out, b^mask[i%4])
+	}
+	return out
+}
+
+func TestFrameParser(t *testing.T) {
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	large := bytes.Repeat([]byte("0123456789"), 7000)
+	tests := []struct {
+		name      string
+		limit     int64
+		stream    []byte
+		opcodes   []int
+		payloads  []string
+		lengths   []int64
+		truncated []bool
+	}{
+		{
+			name:      "unmasked text",
+			limit:     1024,
+			stream:    encodeFrame(true, 0x1, []byte("hello"), nil),
+			opcodes:   []int{0x1},
+			payloads:  []string{"hello"},
+			lengths:   []int64{5},
+			truncated: []bool{false},
+		},
+		{
+			name:      "masked client frame",
+			limit:     1024,
+			stream:    encodeFrame(true, 0x1, []byte("hello world"), mask),
+			opcodes:   []int{0x1},
+			payloads:  []string{"hello world"},
+			lengths:   []int64{11},
+			truncated: []bool{false},
+		},
+		{
+			name:      "16 bit length",
+			limit:     1024,
+			stream:    encodeFrame(true, 0x2, large[:300], mask),
+			opcodes:   []int{0x2},
+			payloads:  []string{string(large[:300])},
+			lengths:   []int64{300},
+			truncated: []bool{false},
+		},
+		{
+			name:      "64 bit length over the limit",
+			limit:     100,
+			stream:    encodeFrame(true, 0x2, large, mask),
+			opcodes:   []int{0x2},
+			payloads:  []string{string(large[:100])},
+			lengths:   []int64{int64(len(large))},
+			truncated: []bool{true},
+		},
+		{
+			name:  "empty frame between others",
+			limit: 1024,
+			stream: bytes.Join([][]byte{
+				encodeFrame(false, 0x1, []byte("ab"), mask),
+				encodeFrame(true, 0x9, nil, mask),
+				encodeFrame(true, 0x0, []byte("cd"), mask),
+			}, nil),
+			opcodes:   []int{0x1, 0x9, 0x0},
+			payloads:  []string{"ab", "", "cd"},
+			lengths:   []int64{2, 0, 2},
+			truncated: []bool{false, false, false},
+		},
+	}
+	for _, tt := range tests {
+		// chunk sizes make headers, masks and payloads cross write boundaries
+		for _, chunk := range []int{1, 3, 7, 1000, len(tt.stream)} {
+			var frames []*Frame
+			p := &frameParser{direction: FromClient, limit: tt.limit, emit: func(f *Frame) { frames = append(frames, f) }}
+			for data := tt.stream; len(data) > 0; {
+				n := min(chunk, len(data))
+				p.write(data[:n])
+				data = data[n:]
+			}
+			if len(frames) != len(tt.payloads) {
+				t.Fatalf("%v (chunk %v): got %v frames, want %v", tt.name, chunk, len(frames), len(tt.payloads))
+			}
+			for i, f := range frames {
+				if f.Opcode != tt.opcodes[i] || string(f.Payload) != tt.payloads[i] ||
+					f.Length != tt.lengths[i] || f.Truncated != tt.truncated[i] || f.Direction != FromClient {
+					t.Errorf("%v (chunk %v): frame %v is {opcode %v, length %v, truncated %v, payload %.20q}, want {%v, %v, %v, %.20q}",
+						tt.name, chunk, i, f.Opcode, f.Length, f.Truncated, f.Payload,
+						tt.opcodes[i], tt.lengths[i], tt.truncated[i], tt.payloads[i])
+				}
+			}
+		}
+	}
+}
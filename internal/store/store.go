@@ -0,0 +1,236 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andrebq/inspector/internal/manager"
+)
+
+type (
+	// Dir is an append-only event store backed by a single
+	// JSON lines file inside a directory
+	Dir struct {
+		lock     sync.RWMutex
+		file     *os.File
+		readOnly bool
+		size     int64
+		index    []entry
+		byID     map[int64]int
+	}
+
+	entry struct {
+		ID     int64
+		Seq    int64
+		Time   time.Time
+		Offset int64
+		Size   int64
+	}
+
+	// record keeps the time used to index the event, which is
+	// the time it was written when the event has no start time
+	record struct {
+		Time  time.Time        `json:"time"`
+		Event *manager.IOEvent `json:"event"`
+	}
+)
+
+const eventsFile = "events.jsonl"
+
+// ErrReadOnly is returned when writing to a store opened with OpenReadOnly
+var ErrReadOnly = errors.New("store is read-only")
+
+// Open opens (or creates) the store kept in dir and rebuilds its index
+func Open(dir string) (*Dir, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, eventsFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return newDir(file, false)
+}
+
+// OpenReadOnly opens the store kept in dir without ever changing it, so it
+// is safe to use while a proxy is writing to the same directory
+func OpenReadOnly(dir string) (*Dir, error) {
+	file, err := os.Open(filepath.Join(dir, eventsFile))
+	if err != nil {
+		return nil, err
+	}
+	return newDir(file, true)
+}
+
+func newDir(file *os.File, readOnly bool) (*Dir, error) {
+	d := &Dir{file: file, readOnly: readOnly, byID: make(map[int64]int)}
+	if err := d.load(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("unable to load %v: %w", file.Name(), err)
+	}
+	return d, nil
+}
+
+// load scans the whole file to build the index, a partially written record
+// at the end of the file (eg.: after a crash) is discarded, unless the store
+// is read-only. Complete records that cannot be decoded are skipped but
+// kept on disk
+func (d *Dir) load() error {
+	rd := bufio.NewReader(d.file)
+	var offset int64
+	for {
+		line, err := rd.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// whatever is left was not terminated, so it was torn by a crash
+			break
+		} else if err != nil {
+			return err
+		}
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil || rec.Event == nil {
+			log.Printf("Skipping unreadable record at offset %v of %v", offset, d.file.Name())
+		} else {
+			d.add(entry{ID: rec.Event.ID, Seq: seqOf(rec.Event), Time: rec.Time, Offset: offset, Size: int64(len(line))})
+		}
+		offset += int64(len(line))
+	}
+	d.size = offset
+	if d.readOnly {
+		return nil
+	}
+	return d.file.Truncate(offset)
+}
+
+// seqOf falls back to the ID for events stored before
+// they had a publish sequence
+func seqOf(ev *manager.IOEvent) int64 {
+	if ev.Seq == 0 {
+		return ev.ID
+	}
+	return ev.Seq
+}
+
+func (d *Dir) add(e entry) {
+	d.byID[e.ID] = len(d.index)
+	d.index = append(d.index, e)
+}
+
+// Append writes ev at the end of the store
+func (d *Dir) Append(ev *manager.IOEvent) error {
+	now := ev.Start
+	if now.IsZero() {
+		now = time.Now()
+	}
+	buf, err := json.Marshal(record{Time: now, Event: ev})
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	if _, err := d.file.WriteAt(buf, d.size); err != nil {
+		return err
+	}
+	d.add(entry{ID: ev.ID, Seq: seqOf(ev), Time: now, Offset: d.size, Size: int64(len(buf))})
+	d.size += int64(len(buf))
+	return nil
+}
+
+// LastID returns the highest event ID ever written to the store
+func (d *Dir) LastID() int64 {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	var last int64
+	for _, e := range d.index {
+		if e.ID > last {
+			last = e.ID
+		}
+	}
+	return last
+}
+
+// LastSeq returns the highest publish sequence ever written to the store
+func (d *Dir) LastSeq() int64 {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	var last int64
+	for _, e := range d.index {
+		if e.Seq > last {
+			last = e.Seq
+		}
+	}
+	return last
+}
+
+// Get returns the event with the given id
+func (d *Dir) Get(id int64) (*manager.IOEvent, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	idx, ok := d.byID[id]
+	if !ok {
+		return nil, manager.ErrNotFound
+	}
+	return d.read(d.index[idx])
+}
+
+// Query returns, ordered by ID, the events matching q
+func (d *Dir) Query(q manager.Query) ([]*manager.IOEvent, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	var matches []entry
+	for _, e := range d.index {
+		if !q.MatchID(e.ID) || !q.MatchTime(e.Time) || e.Seq <= q.AfterSeq {
+			continue
+		}
+		matches = append(matches, e)
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].ID < matches[j].ID })
+	if q.Method == "" && q.Code == 0 && q.URL == "" && q.Limit > 0 && len(matches) > q.Limit {
+		// the index alone decides what matches, no need to read what Trim drops
+		matches = matches[len(matches)-q.Limit:]
+	}
+	var out []*manager.IOEvent
+	for _, e := range matches {
+		ev, err := d.read(e)
+		if err != nil {
+			return nil, err
+		}
+		if q.Match(ev) {
+			out = append(out, ev)
+		}
+	}
+	return q.Trim(out), nil
+}
+
+func (d *Dir) read(e entry) (*manager.IOEvent, error) {
+	buf := make([]byte, e.Size)
+	if _, err := d.file.ReadAt(buf, e.Offset); err != nil {
+		return nil, err
+	}
+	var rec record
+	if err := json.NewDecoder(bytes.NewReader(buf)).Decode(&rec); err != nil {
+		return nil, err
+	}
+	return rec.Event, nil
+}
+
+// Close releases the underlying file
+func (d *Dir) Close() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.file.Close()
+}
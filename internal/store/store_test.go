@@ -0,0 +1,116 @@
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrebq/inspector/internal/manager"
+)
+
+func line(id, seq int64) string {
+	buf, err := json.Marshal(record{Time: time.Unix(id, 0).UTC(), Event: &manager.IOEvent{ID: id, Seq: seq}})
+	if err != nil {
+		panic(err)
+	}
+	return string(buf) + "\n"
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		readOnly bool
+		ids      []int64
+		lastSeq  int64
+		// size the file must have after loading
+		size int
+	}{
+		{name: "empty", content: "", ids: nil},
+		{name: "clean", content: line(1, 1) + line(2, 2), ids: []int64{1, 2}, lastSeq: 2},
+		{name: "torn tail", content: line(1, 1) + line(2, 2) + `{"time":"20`, ids: []int64{1, 2}, lastSeq: 2, size: len(line(1, 1) + line(2, 2))},
+		{name: "torn tail read-only", content: line(1, 1) + `{"time":"20`, readOnly: true, ids: []int64{1}, lastSeq: 1},
+		{name: "corrupt middle", content: line(1, 1) + "garbage\n" + line(3, 3), ids: []int64{1, 3}, lastSeq: 3},
+		{name: "null event", content: line(1, 1) + `{"time":"2024-01-01T00:00:00Z","event":null}` + "\n" + line(2, 2), ids: []int64{1, 2}, lastSeq: 2},
+		{name: "missing seq falls back to id", content: line(4, 0) + line(5, 0), ids: []int64{4, 5}, lastSeq: 5},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		name := filepath.Join(dir, eventsFile)
+		if err := os.WriteFile(name, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		open := Open
+		if tt.readOnly {
+			open = OpenReadOnly
+		}
+		d, err := open(dir)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		evs, err := d.Query(manager.Query{})
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		var ids []int64
+		for _, ev := range evs {
+			ids = append(ids, ev.ID)
+		}
+		if len(ids) != len(tt.ids) {
+			t.Errorf("%v: got ids %v, want %v", tt.name, ids, tt.ids)
+		} else {
+			for i := range ids {
+				if ids[i] != tt.ids[i] {
+					t.Errorf("%v: got ids %v, want %v", tt.name, ids, tt.ids)
+					break
+				}
+			}
+		}
+		if got := d.LastSeq(); got != tt.lastSeq {
+			t.Errorf("%v: got last seq %v, want %v", tt.name, got, tt.lastSeq)
+		}
+		d.Close()
+		size := tt.size
+		if size == 0 {
+			size = len(tt.content)
+		}
+		if buf, _ := os.ReadFile(name); len(buf) != size {
+			t.Errorf("%v: file has %v bytes after loading, want %v", tt.name, len(buf), size)
+		}
+	}
+}
+
+func TestAppendAfterRecovery(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, eventsFile)
+	if err := os.WriteFile(name, []byte(line(1, 1)+"garbage\n"+`{"time":"20`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Append(&manager.IOEvent{ID: 2, Seq: 2}); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	ro, err := OpenReadOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	if err := ro.Append(&manager.IOEvent{ID: 3}); err != ErrReadOnly {
+		t.Errorf("append on a read-only store returned %v", err)
+	}
+	for _, id := range []int64{1, 2} {
+		if ev, err := ro.Get(id); err != nil || ev.ID != id {
+			t.Errorf("get %v: %v %v", id, ev, err)
+		}
+	}
+	if _, err := ro.Get(3); err != manager.ErrNotFound {
+		t.Errorf("get 3 returned %v", err)
+	}
+}